@@ -9,18 +9,35 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/cache/redis"
 	"github.com/shipperizer/iam-ext-authz/internal/config"
 	ih "github.com/shipperizer/iam-ext-authz/internal/hydra"
 	ik "github.com/shipperizer/iam-ext-authz/internal/kratos"
 	"github.com/shipperizer/iam-ext-authz/internal/logging"
 	"github.com/shipperizer/iam-ext-authz/internal/monitoring/prometheus"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
 	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+	"github.com/shipperizer/iam-ext-authz/pkg/apikey"
+	"github.com/shipperizer/iam-ext-authz/pkg/authz"
+	"github.com/shipperizer/iam-ext-authz/pkg/dpop"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+	"github.com/shipperizer/iam-ext-authz/pkg/opa"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/provider"
+	"github.com/shipperizer/iam-ext-authz/pkg/relyingparty"
+	"github.com/shipperizer/iam-ext-authz/pkg/serviceaccount"
+	"github.com/shipperizer/iam-ext-authz/pkg/tenant"
+	"github.com/shipperizer/iam-ext-authz/pkg/tokenexchange"
 	"github.com/shipperizer/iam-ext-authz/pkg/web"
 )
 
@@ -55,7 +72,244 @@ func serve() {
 	kClient := ik.NewClient(specs.KratosPublicURL, specs.Debug)
 	hClient := ih.NewClient(specs.HydraAdminURL, specs.Debug)
 
-	router := web.NewRouter(kClient, hClient, ollyConfig)
+	var sharedCache cache.CacheInterface
+	var redisCache *redis.Cache
+	var memoryCache *cache.Memory
+
+	switch specs.CacheBackend {
+	case "redis":
+		redisCache = redis.NewCache(specs.RedisAddress, specs.RedisPassword, specs.RedisDB, logger)
+		sharedCache = redisCache
+	case "memory":
+		memoryCache = cache.NewMemory()
+		sharedCache = memoryCache
+	default:
+		panic(fmt.Errorf("unsupported cache backend: %s", specs.CacheBackend))
+	}
+
+	var limiter ratelimit.LimiterInterface
+	var localLimiter *ratelimit.Local
+
+	switch specs.RateLimitBackend {
+	case "cache":
+		if redisCache == nil {
+			panic(fmt.Errorf("rate limit backend cache needs the redis cache backend"))
+		}
+
+		limiter = ratelimit.NewRedis(redisCache.Client())
+	case "local":
+		localLimiter = ratelimit.NewLocal()
+		limiter = localLimiter
+	default:
+		panic(fmt.Errorf("unsupported rate limit backend: %s", specs.RateLimitBackend))
+	}
+
+	policies, err := policy.Load(specs.PoliciesFile)
+
+	if err != nil {
+		panic(fmt.Errorf("issues with policies: %s", err))
+	}
+
+	policyStore := policy.NewStore(policies, specs.PoliciesFile)
+	policyAdminSecret := specs.PolicyAdminSecret
+
+	if specs.PolicyBundleURL != "" {
+		// uploads are not signed, only signed bundles are activated when a bundle source is set
+		if policyAdminSecret != "" {
+			logger.Warn("policy bundle source configured, policy upload disabled")
+			policyAdminSecret = ""
+		}
+
+		rawKey, err := os.ReadFile(specs.PolicyBundlePublicKey)
+
+		if err != nil {
+			panic(fmt.Errorf("policy bundles need a public key: %s", err))
+		}
+
+		publicKey, err := policy.ParsePublicKey(rawKey)
+
+		if err != nil {
+			panic(fmt.Errorf("issues with policy bundle public key: %s", err))
+		}
+
+		signatureURL := specs.PolicyBundleSignatureURL
+
+		if signatureURL == "" {
+			signatureURL = specs.PolicyBundleURL + ".sig"
+		}
+
+		poller := policy.NewPoller(
+			specs.PolicyBundleURL, signatureURL, publicKey, specs.PolicyBundleCacheFile,
+			&http.Client{Timeout: 10 * time.Second}, policyStore, tracer, logger,
+		)
+
+		// without bundle the policies file, if any, stays active until the next successful poll
+		if err := poller.Poll(context.Background()); err != nil {
+			logger.Errorf("unable to load policy bundle: %v", err)
+		}
+
+		go poller.Watch(context.Background(), specs.PolicyBundlePollInterval)
+	}
+
+	claims, err := provider.NewClaimsMapping(specs.IDTokenClaims)
+
+	if err != nil {
+		panic(fmt.Errorf("issues with id token claims mapping: %s", err))
+	}
+
+	newProviderService := func(kratos *ik.Client, hydra *ih.Client) provider.ServiceInterface {
+		return provider.NewService(kratos, hydra, claims, specs.LoginRememberFor, specs.ConsentRememberFor, specs.TrustedClients, tracer, monitor, logger)
+	}
+
+	tenants, err := tenant.Load(specs.TenantsFile)
+
+	if err != nil {
+		panic(fmt.Errorf("issues with tenants: %s", err))
+	}
+
+	tenantPolicies, err := tenants.LoadStores()
+
+	if err != nil {
+		panic(fmt.Errorf("issues with tenant policies: %s", err))
+	}
+
+	var rpService *relyingparty.Service
+
+	if specs.RelyingPartyEnabled {
+		codec, err := relyingparty.NewCookieCodec(specs.RPCookieKey)
+
+		if err != nil {
+			panic(fmt.Errorf("issues with relying party cookie key: %s", err))
+		}
+
+		rpService = relyingparty.NewService(
+			&relyingparty.Config{
+				IssuerURL:             specs.HydraPublicURL,
+				ClientID:              specs.RPClientID,
+				ClientSecret:          specs.RPClientSecret,
+				RedirectURL:           specs.RPRedirectURL,
+				PostLogoutRedirectURL: specs.RPPostLogoutRedirect,
+				Scopes:                specs.RPScopes,
+				CookieName:            specs.RPCookieName,
+				CookieDomain:          specs.RPCookieDomain,
+			},
+			codec,
+			tracer,
+			logger,
+		)
+	}
+
+	var exchanger *tokenexchange.Service
+
+	if specs.TokenExchangeClientID != "" {
+		tokenURL := specs.TokenExchangeURL
+
+		if tokenURL == "" {
+			tokenURL = fmt.Sprintf("%s/oauth2/token", specs.HydraPublicURL)
+		}
+
+		exchanger = tokenexchange.NewService(
+			tokenURL, specs.TokenExchangeClientID, specs.TokenExchangeClientSecret, sharedCache, tracer, monitor, logger,
+		)
+	}
+
+	dpopValidator := dpop.NewValidator(specs.DPoPProofMaxAge, specs.DPoPProofLeeway, sharedCache, tracer, logger)
+
+	var apiKeys *apikey.Store
+
+	if specs.APIKeysFile != "" {
+		apiKeys, err = apikey.NewStore(specs.APIKeysFile, tracer, logger)
+
+		if err != nil {
+			panic(fmt.Errorf("issues with API keys: %s", err))
+		}
+
+		go apiKeys.Watch(context.Background(), specs.APIKeysReloadInterval)
+	}
+
+	var regoEvaluator *opa.Evaluator
+
+	if specs.RegoModules != "" {
+		modules, err := opa.LoadModules(specs.RegoModules)
+
+		if err != nil {
+			panic(fmt.Errorf("issues with rego modules: %s", err))
+		}
+
+		if regoEvaluator, err = opa.NewEvaluator(modules, tracer, monitor, logger); err != nil {
+			panic(fmt.Errorf("issues compiling rego modules: %s", err))
+		}
+	}
+
+	jwtIssuers, err := trustedIssuers(specs, tracer, logger)
+
+	if err != nil {
+		panic(fmt.Errorf("issues with trusted issuers: %s", err))
+	}
+
+	serviceAccounts, err := serviceAccountValidator(specs, tracer, logger)
+
+	if err != nil {
+		panic(fmt.Errorf("issues with kubernetes token validation: %s", err))
+	}
+
+	debug, err := authz.NewDebugConfig(specs.DemoMode, specs.DebugEchoNetworks)
+
+	if err != nil {
+		panic(fmt.Errorf("issues with debug configuration: %s", err))
+	}
+
+	// logged at error level so that it shows up with the default log level
+	if debug.DemoMode {
+		logger.Errorf("DEMO MODE ENABLED: every request carrying the `x-ext-authz: allow` header is authorized, never run this in production")
+	}
+
+	if len(debug.EchoNetworks) > 0 {
+		logger.Warnf("debug echo enabled for clients in %v", specs.DebugEchoNetworks)
+	}
+
+	sessionCache := authz.NewSessionCache(sharedCache, specs.SessionCacheTTL, specs.KratosSessionCookie, tracer, logger)
+
+	var sessionExtender *authz.SessionExtender
+
+	if specs.KratosAdminURL != "" && specs.SessionExtendWindow > 0 {
+		sessionExtender = authz.NewSessionExtender(
+			ik.NewClient(specs.KratosAdminURL, specs.Debug), specs.SessionExtendWindow, sharedCache, tracer, logger,
+		)
+	}
+
+	router := web.NewRouter(
+		&web.RouterConfig{
+			Kratos:             kClient,
+			Hydra:              hClient,
+			SessionCache:       sessionCache,
+			SessionExtender:    sessionExtender,
+			Policies:           policyStore,
+			Limiter:            limiter,
+			NewProviderService: newProviderService,
+			LoginUIURL:         specs.LoginUIURL,
+			ConsentUIURL:       specs.ConsentUIURL,
+			RelyingParty:       rpService,
+			Exchanger:          exchanger,
+			DPoP:               dpopValidator,
+			APIKeys:            apiKeys,
+			JWTIssuers:         jwtIssuers,
+			ServiceAccounts:    serviceAccounts,
+			Rego:               regoEvaluator,
+			Debug:              debug,
+			Challenges:         authz.NewChallengeConfig(specs.AuthRealm, specs.ResourceMetadataURL),
+			Issuer:             jwtIssuer(specs),
+			MaxBodyBytes:       specs.MaxBodyBytes,
+			TrustedProxyHops:   specs.TrustedProxyHops,
+			XFCCBy:             specs.XFCCTrustedBy,
+			Tenants:            tenants,
+			TenantPolicies:     tenantPolicies,
+			ClientDebug:        specs.Debug,
+		},
+		ollyConfig,
+	)
+
+	adminRouter := web.NewAdminRouter(sessionCache, specs.SessionWebhookSecret, policyStore, tenantPolicies, policyAdminSecret, specs.PprofEnabled, ollyConfig)
 
 	logger.Infof("Starting server on port %v", specs.Port)
 
@@ -68,7 +322,24 @@ func serve() {
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal(err)
+		}
+	}()
+
+	logger.Infof("Starting admin server on %s:%v", specs.AdminAddress, specs.AdminPort)
+
+	// pprof profiles take up to 30 seconds by default, leave room for them
+	adminSrv := &http.Server{
+		Addr:         fmt.Sprintf("%s:%v", specs.AdminAddress, specs.AdminPort),
+		WriteTimeout: time.Second * 60,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+		Handler:      adminRouter,
+	}
+
+	go func() {
+		if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Fatal(err)
 		}
 	}()
@@ -85,6 +356,15 @@ func serve() {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	adminSrv.Shutdown(ctx)
+
+	if memoryCache != nil {
+		memoryCache.Close()
+	}
+
+	if localLimiter != nil {
+		localLimiter.Close()
+	}
 
 	logger.Desugar().Sync()
 
@@ -95,3 +375,80 @@ func serve() {
 	os.Exit(0)
 
 }
+
+// serviceAccountValidator builds the validator of kubernetes service account tokens, nil when
+// disabled
+func serviceAccountValidator(specs *config.EnvSpec, tracer tracing.TracingInterface, logger logging.LoggerInterface) (authz.ServiceAccountValidatorInterface, error) {
+	if specs.KubernetesTokenValidation == "" {
+		return nil, nil
+	}
+
+	// service account tokens of every pod in the cluster share the issuer, without an audience
+	// a token minted for any other service would be accepted
+	if !slices.ContainsFunc(specs.KubernetesAudiences, func(aud string) bool { return strings.TrimSpace(aud) != "" }) {
+		return nil, fmt.Errorf("kubernetes token validation requires at least one audience")
+	}
+
+	client, err := serviceaccount.NewClient(specs.KubernetesCAFile, specs.KubernetesTokenFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch specs.KubernetesTokenValidation {
+	case "jwks":
+		jwksURL := specs.KubernetesJWKSURL
+
+		if jwksURL == "" {
+			jwksURL = fmt.Sprintf("%s/openid/v1/jwks", strings.TrimSuffix(specs.KubernetesAPIURL, "/"))
+		}
+
+		verifier := oidc.NewVerifier(
+			specs.KubernetesIssuer, specs.KubernetesAudiences, oidc.NewKeySet(jwksURL, client, tracer, logger), tracer, logger,
+		)
+
+		return serviceaccount.NewJWKSValidator(verifier, tracer, logger), nil
+	case "tokenreview":
+		return serviceaccount.NewTokenReviewer(specs.KubernetesAPIURL, specs.KubernetesIssuer, specs.KubernetesAudiences, client, tracer, logger), nil
+	default:
+		return nil, fmt.Errorf("unsupported kubernetes token validation: %s", specs.KubernetesTokenValidation)
+	}
+}
+
+// trustedIssuers builds the verifiers of JWT access tokens, the JWT issuer comes first and the
+// trusted issuers file adds external ones, nil when there is none
+func trustedIssuers(specs *config.EnvSpec, tracer tracing.TracingInterface, logger logging.LoggerInterface) (*oidc.Issuers, error) {
+	trusted, err := oidc.LoadIssuers(specs.TrustedIssuersFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if issuer := jwtIssuer(specs); issuer != "" {
+		jwksURL := specs.JWTJWKSURL
+
+		if jwksURL == "" {
+			jwksURL = fmt.Sprintf("%s/.well-known/jwks.json", strings.TrimSuffix(issuer, "/"))
+		}
+
+		trusted = append([]oidc.TrustedIssuer{{Issuer: issuer, JWKSURL: jwksURL, Audiences: specs.JWTAudiences, Default: true}}, trusted...)
+	}
+
+	if len(trusted) == 0 {
+		return nil, nil
+	}
+
+	// key sets are fetched while requests wait for their verification, never block on an issuer
+	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: 10 * time.Second}
+
+	return oidc.NewIssuers(trusted, client, tracer, logger)
+}
+
+// jwtIssuer returns the issuer of the JWT access tokens, defaults to hydra
+func jwtIssuer(specs *config.EnvSpec) string {
+	if specs.JWTIssuer != "" || specs.HydraPublicURL == "" {
+		return specs.JWTIssuer
+	}
+
+	return fmt.Sprintf("%s/", strings.TrimSuffix(specs.HydraPublicURL, "/"))
+}
@@ -0,0 +1,16 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+type CacheInterface interface {
+	Get(context.Context, string) ([]byte, error)
+	Set(context.Context, string, []byte, time.Duration) error
+	SetIfAbsent(context.Context, string, []byte, time.Duration) (bool, error)
+	Delete(context.Context, ...string) error
+}
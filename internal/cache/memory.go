@@ -0,0 +1,137 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// ErrCacheMiss is returned by every cache implementation when a key is not present or expired
+var ErrCacheMiss = errors.New("cache miss")
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// Memory is a process local cache, entries are not shared across replicas
+type Memory struct {
+	entries map[string]*entry
+
+	mu sync.RWMutex
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key]
+
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrCacheMiss
+	}
+
+	return e.value, nil
+}
+
+func (c *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := new(entry)
+	e.value = value
+
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+
+	c.entries[key] = e
+
+	return nil
+}
+
+// SetIfAbsent stores the value only if the key is missing or expired, returns whether it was stored
+func (c *Memory) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok && !e.expired(time.Now()) {
+		return false, nil
+	}
+
+	e := new(entry)
+	e.value = value
+
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+
+	c.entries[key] = e
+
+	return true, nil
+}
+
+func (c *Memory) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+
+	return nil
+}
+
+// Close stops the background sweep of expired entries
+func (c *Memory) Close() {
+	c.closeOnce.Do(func() { close(c.stop) })
+}
+
+func (c *Memory) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.removeExpired(now)
+		}
+	}
+}
+
+func (c *Memory) removeExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.entries {
+		if e.expired(now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func NewMemory() *Memory {
+	c := new(Memory)
+
+	c.entries = make(map[string]*entry)
+	c.stop = make(chan struct{})
+
+	go c.sweep()
+
+	return c
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySetGetDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewMemory()
+
+	assert.Nil(c.Set(context.TODO(), "key", []byte("value"), time.Minute))
+
+	value, err := c.Get(context.TODO(), "key")
+	assert.Nil(err)
+	assert.Equal([]byte("value"), value)
+
+	assert.Nil(c.Delete(context.TODO(), "key"))
+
+	_, err = c.Get(context.TODO(), "key")
+	assert.ErrorIs(err, ErrCacheMiss)
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewMemory()
+
+	assert.Nil(c.Set(context.TODO(), "key", []byte("value"), time.Millisecond))
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err := c.Get(context.TODO(), "key")
+	assert.ErrorIs(err, ErrCacheMiss)
+}
+
+func TestMemorySetIfAbsent(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewMemory()
+
+	stored, err := c.SetIfAbsent(context.TODO(), "key", []byte("first"), time.Minute)
+	assert.Nil(err)
+	assert.True(stored)
+
+	stored, err = c.SetIfAbsent(context.TODO(), "key", []byte("second"), time.Minute)
+	assert.Nil(err)
+	assert.False(stored)
+
+	value, _ := c.Get(context.TODO(), "key")
+	assert.Equal([]byte("first"), value)
+}
+
+func TestMemorySweep(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewMemory()
+	defer c.Close()
+
+	assert.Nil(c.Set(context.TODO(), "expired", []byte("value"), time.Millisecond))
+	assert.Nil(c.Set(context.TODO(), "live", []byte("value"), time.Minute))
+
+	c.removeExpired(time.Now().Add(time.Second))
+
+	assert.Len(c.entries, 1)
+	assert.Contains(c.entries, "live")
+}
+
+func TestMemoryClose(t *testing.T) {
+	c := NewMemory()
+
+	assert.NotPanics(t, func() {
+		c.Close()
+		c.Close()
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+)
+
+const keyPrefix = "iam-ext-authz:"
+
+// Cache is backed by a redis server shared by all the replicas, writes and deletes are
+// visible to every instance as soon as they are acknowledged
+type Cache struct {
+	client *redis.Client
+
+	logger logging.LoggerInterface
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client.Get(ctx, keyPrefix+key).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		return nil, cache.ErrCacheMiss
+	}
+
+	return value, err
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, keyPrefix+key, value, ttl).Err()
+}
+
+// SetIfAbsent stores the value only if the key is missing, returns whether it was stored
+func (c *Cache) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, keyPrefix+key, value, ttl).Result()
+}
+
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		prefixed = append(prefixed, keyPrefix+key)
+	}
+
+	return c.client.Del(ctx, prefixed...).Err()
+}
+
+// Client exposes the underlying redis client for components that need atomic primitives
+func (c *Cache) Client() *redis.Client {
+	return c.client
+}
+
+func NewCache(address, password string, db int, logger logging.LoggerInterface) *Cache {
+	c := new(Cache)
+
+	c.client = redis.NewClient(
+		&redis.Options{
+			Addr:     address,
+			Password: password,
+			DB:       db,
+		},
+	)
+	c.logger = logger
+
+	return c
+}
@@ -3,6 +3,10 @@
 
 package config
 
+import (
+	"time"
+)
+
 // EnvSpec is the basic environment configuration setup needed for the app to start
 type EnvSpec struct {
 	OtelGRPCEndpoint string `envconfig:"otel_grpc_endpoint"`
@@ -14,8 +18,97 @@ type EnvSpec struct {
 
 	Port int `envconfig:"port" default:"8000"`
 
+	AdminAddress string `envconfig:"admin_address" default:"127.0.0.1"`
+	AdminPort    int    `envconfig:"admin_port" default:"8001"`
+	// PprofEnabled serves the go profiling endpoints on the admin listener
+	PprofEnabled bool `envconfig:"pprof_enabled" default:"false"`
+
 	Debug bool `envconfig:"debug" default:"false"`
 
+	DemoMode          bool     `envconfig:"demo_mode" default:"false"`
+	DebugEchoNetworks []string `envconfig:"debug_echo_networks"`
+
 	KratosPublicURL string `envconfig:"kratos_public_url" required:"false"`
 	HydraAdminURL   string `envconfig:"hydra_admin_url" required:"false"`
+
+	KratosSessionCookie string `envconfig:"kratos_session_cookie" default:"ory_kratos_session"`
+
+	KratosAdminURL      string        `envconfig:"kratos_admin_url"`
+	SessionExtendWindow time.Duration `envconfig:"session_extend_window"`
+
+	CacheBackend  string `envconfig:"cache_backend" default:"memory"`
+	RedisAddress  string `envconfig:"redis_address" default:"localhost:6379"`
+	RedisPassword string `envconfig:"redis_password"`
+	RedisDB       int    `envconfig:"redis_db" default:"0"`
+
+	SessionCacheTTL      time.Duration `envconfig:"session_cache_ttl" default:"0s"`
+	SessionWebhookSecret string        `envconfig:"session_webhook_secret"`
+
+	LoginUIURL         string        `envconfig:"login_ui_url"`
+	IDTokenClaims      string        `envconfig:"id_token_claims" default:"email=traits.email"`
+	LoginRememberFor   time.Duration `envconfig:"login_remember_for" default:"24h"`
+	ConsentRememberFor time.Duration `envconfig:"consent_remember_for" default:"720h"`
+	ConsentUIURL       string        `envconfig:"consent_ui_url"`
+	TrustedClients     []string      `envconfig:"trusted_clients"`
+
+	HydraPublicURL string `envconfig:"hydra_public_url"`
+
+	RelyingPartyEnabled  bool     `envconfig:"rp_enabled" default:"false"`
+	RPClientID           string   `envconfig:"rp_client_id"`
+	RPClientSecret       string   `envconfig:"rp_client_secret"`
+	RPRedirectURL        string   `envconfig:"rp_redirect_url"`
+	RPPostLogoutRedirect string   `envconfig:"rp_post_logout_redirect_url"`
+	RPScopes             []string `envconfig:"rp_scopes" default:"openid,offline_access"`
+	RPCookieKey          string   `envconfig:"rp_cookie_key"`
+	RPCookieName         string   `envconfig:"rp_cookie_name" default:"iam_ext_authz_session"`
+	RPCookieDomain       string   `envconfig:"rp_cookie_domain"`
+
+	TokenExchangeURL          string `envconfig:"token_exchange_url"`
+	TokenExchangeClientID     string `envconfig:"token_exchange_client_id"`
+	TokenExchangeClientSecret string `envconfig:"token_exchange_client_secret"`
+
+	DPoPProofMaxAge time.Duration `envconfig:"dpop_proof_max_age" default:"60s"`
+	DPoPProofLeeway time.Duration `envconfig:"dpop_proof_leeway" default:"5s"`
+
+	APIKeysFile           string        `envconfig:"api_keys_file"`
+	APIKeysReloadInterval time.Duration `envconfig:"api_keys_reload_interval" default:"30s"`
+
+	JWTIssuer    string   `envconfig:"jwt_issuer"`
+	JWTJWKSURL   string   `envconfig:"jwt_jwks_url"`
+	JWTAudiences []string `envconfig:"jwt_audiences"`
+
+	TrustedIssuersFile string `envconfig:"trusted_issuers_file"`
+
+	KubernetesTokenValidation string   `envconfig:"kubernetes_token_validation"`
+	KubernetesAPIURL          string   `envconfig:"kubernetes_api_url" default:"https://kubernetes.default.svc"`
+	KubernetesIssuer          string   `envconfig:"kubernetes_issuer" default:"https://kubernetes.default.svc.cluster.local"`
+	KubernetesJWKSURL         string   `envconfig:"kubernetes_jwks_url"`
+	KubernetesAudiences       []string `envconfig:"kubernetes_audiences"`
+	KubernetesCAFile          string   `envconfig:"kubernetes_ca_file" default:"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"`
+	KubernetesTokenFile       string   `envconfig:"kubernetes_token_file" default:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+
+	MaxBodyBytes int64 `envconfig:"max_body_bytes" default:"65536"`
+
+	TrustedProxyHops int `envconfig:"trusted_proxy_hops" default:"1"`
+	// XFCCTrustedBy is the URI SAN of the envoy setting x-forwarded-client-cert, the header is
+	// ignored when empty
+	XFCCTrustedBy string `envconfig:"xfcc_trusted_by"`
+
+	AuthRealm           string `envconfig:"auth_realm"`
+	ResourceMetadataURL string `envconfig:"resource_metadata_url"`
+
+	TenantsFile string `envconfig:"tenants_file"`
+
+	PoliciesFile      string `envconfig:"policies_file"`
+	PolicyAdminSecret string `envconfig:"policy_admin_secret"`
+
+	PolicyBundleURL          string        `envconfig:"policy_bundle_url"`
+	PolicyBundleSignatureURL string        `envconfig:"policy_bundle_signature_url"`
+	PolicyBundlePublicKey    string        `envconfig:"policy_bundle_public_key"`
+	PolicyBundleCacheFile    string        `envconfig:"policy_bundle_cache_file"`
+	PolicyBundlePollInterval time.Duration `envconfig:"policy_bundle_poll_interval" default:"60s"`
+
+	RegoModules string `envconfig:"rego_modules"`
+
+	RateLimitBackend string `envconfig:"rate_limit_backend" default:"local"`
 }
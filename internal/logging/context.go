@@ -0,0 +1,45 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package logging
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type contextKey int
+
+const loggerKey contextKey = iota
+
+// fieldLogger is implemented by loggers able to carry fields, like the zap sugared logger
+type fieldLogger interface {
+	With(...interface{}) *zap.SugaredLogger
+}
+
+// FromContext returns the logger carried by the context, fallback when there is none
+func FromContext(ctx context.Context, fallback LoggerInterface) LoggerInterface {
+	if logger, ok := ctx.Value(loggerKey).(LoggerInterface); ok {
+		return logger
+	}
+
+	return fallback
+}
+
+// WithLogger returns a copy of the context carrying the logger
+func WithLogger(ctx context.Context, logger LoggerInterface) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// WithFields returns a copy of the context whose logger adds the key value pairs to every line,
+// loggers not supporting fields are carried as they are
+func WithFields(ctx context.Context, fallback LoggerInterface, keysAndValues ...interface{}) context.Context {
+	logger := FromContext(ctx, fallback)
+
+	if l, ok := logger.(fieldLogger); ok {
+		logger = l.With(keysAndValues...)
+	}
+
+	return WithLogger(ctx, logger)
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestContext(t *testing.T) {
+	assert := assert.New(t)
+
+	core, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(core).Sugar()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+
+	handler := middleware.RequestID(RequestContext(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithFields(r.Context(), logger, "subject", "alice")
+
+		FromContext(ctx, NewNoopLogger()).Info("allowed")
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	r.Header.Set(middleware.RequestIDHeader, "req-1")
+	r = r.WithContext(trace.ContextWithSpanContext(r.Context(), sc))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, r)
+
+	assert.Equal("req-1", w.Header().Get(middleware.RequestIDHeader))
+	assert.Equal(1, logs.Len())
+	assert.Equal(map[string]interface{}{
+		"request_id": "req-1",
+		"trace_id":   traceID.String(),
+		"span_id":    spanID.String(),
+		"subject":    "alice",
+	}, logs.All()[0].ContextMap())
+}
+
+func TestFromContextFallback(t *testing.T) {
+	logger := NewNoopLogger()
+
+	assert.Equal(t, LoggerInterface(logger), FromContext(context.TODO(), logger))
+}
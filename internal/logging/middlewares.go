@@ -10,8 +10,32 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// RequestContext stores a logger carrying request id, trace id and span id in the context of
+// every request, the request id is sent back in the X-Request-Id response header; it needs to
+// run after middleware.RequestID
+func RequestContext(logger LoggerInterface) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			fields := make([]interface{}, 0, 6)
+
+			if reqID := middleware.GetReqID(ctx); reqID != "" {
+				fields = append(fields, "request_id", reqID)
+				w.Header().Set(middleware.RequestIDHeader, reqID)
+			}
+
+			if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+				fields = append(fields, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithFields(ctx, logger, fields...)))
+		})
+	}
+}
+
 // brain-picked from DefaultLogFormatter https://raw.githubusercontent.com/go-chi/chi/v5.0.8/middleware/logger.go
 
 // LogFormatter is a simple logger that implements a middleware.LogFormatter.
@@ -55,7 +79,7 @@ func (l *LogEntry) Write(status, bytes int, header http.Header, elapsed time.Dur
 
 	fmt.Fprintf(l.buf, "%v %03d %dB in %s", header, status, bytes, elapsed)
 
-	l.Logger.Debug(l.buf.String())
+	FromContext(l.request.Context(), l.Logger).Debug(l.buf.String())
 }
 
 // TODO @shipperizer see if implementing this or not
@@ -6,6 +6,7 @@ package monitoring
 type MonitorInterface interface {
 	GetService() string
 	GetResponseTimeMetric(map[string]string) (MetricInterface, error)
+	GetPolicyEvaluationMetric(map[string]string) (MetricInterface, error)
 }
 
 type MetricInterface interface {
@@ -0,0 +1,50 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package monitoring
+
+import (
+	"context"
+	"sync"
+)
+
+// TenantLabel is set on metrics of requests resolved to a tenant
+const TenantLabel = "tenant"
+
+type labelsKey struct{}
+
+// Labels carries metric labels that are only known by handlers down the chain, the set of
+// label names is fixed to keep the metrics schema stable
+type Labels struct {
+	values map[string]string
+	mu     sync.Mutex
+}
+
+func (l *Labels) Get(name string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.values[name]
+}
+
+func (l *Labels) Set(name, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.values[name] = value
+}
+
+// ContextWithLabels attaches an empty label set to the context
+func ContextWithLabels(ctx context.Context) (context.Context, *Labels) {
+	l := new(Labels)
+	l.values = make(map[string]string)
+
+	return context.WithValue(ctx, labelsKey{}, l), l
+}
+
+// SetLabel sets a label on the metrics of the current request, no-op outside of the middleware
+func SetLabel(ctx context.Context, name, value string) {
+	if l, ok := ctx.Value(labelsKey{}).(*Labels); ok {
+		l.Set(name, value)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/shipperizer/iam-ext-authz/internal/logging"
@@ -18,6 +19,8 @@ const (
 	// IDPathRegex regexp used to swap the {id*} parameters in the path with simply id
 	// supports alphabetic characters and underscores, no dashes
 	IDPathRegex string = "{[a-zA-Z_]*}"
+	// unmatchedRoute is the route label of requests not matching any route
+	unmatchedRoute string = "/unmatched"
 )
 
 // Middleware is the monitoring middleware object implementing Prometheus monitoring
@@ -36,11 +39,14 @@ func (mdw *Middleware) ResponseTime() func(http.Handler) http.Handler {
 				ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 				startTime := time.Now()
 
-				next.ServeHTTP(ww, r)
+				ctx, labels := ContextWithLabels(r.Context())
+
+				next.ServeHTTP(ww, r.WithContext(ctx))
 
 				tags := map[string]string{
-					"route":  fmt.Sprintf("%s%s", r.Method, mdw.regex.ReplaceAll([]byte(r.URL.Path), []byte("id"))),
-					"status": fmt.Sprint(ww.Status()),
+					"route":     fmt.Sprintf("%s%s", r.Method, mdw.regex.ReplaceAll([]byte(routePattern(r)), []byte("id"))),
+					"status":    fmt.Sprint(ww.Status()),
+					TenantLabel: labels.Get(TenantLabel),
 				}
 
 				m, err := mdw.monitor.GetResponseTimeMetric(tags)
@@ -57,6 +63,16 @@ func (mdw *Middleware) ResponseTime() func(http.Handler) http.Handler {
 	}
 }
 
+// routePattern returns the pattern of the route matched by the request, never its path, so that
+// the route label stays bounded
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+
+	return unmatchedRoute
+}
+
 // NewMiddleware returns a Middleware based on the type of monitor
 func NewMiddleware(monitor MonitorInterface, logger logging.LoggerInterface) *Middleware {
 	mdw := new(Middleware)
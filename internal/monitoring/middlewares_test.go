@@ -61,3 +61,29 @@ func TestMiddlewareResponseTime(t *testing.T) {
 
 	router.ServeHTTP(rr, req)
 }
+
+func TestMiddlewareRouteLabelUsesPattern(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMonitor := NewMockMonitorInterface(ctrl)
+	mockMetric := NewMockMetricInterface(ctrl)
+	mockLogger := NewMockLoggerInterface(ctrl)
+	mockMonitor.EXPECT().GetService().Times(1)
+	mockMetric.EXPECT().Observe(gomock.Any()).Times(3)
+
+	for _, route := range []string{"GET/api/items/id", "GET/api/check/*", "GET/unmatched"} {
+		mockMonitor.EXPECT().GetResponseTimeMetric(map[string]string{"route": route, "status": "200", TenantLabel: ""}).Times(1).Return(mockMetric, nil)
+	}
+
+	router := chi.NewMux()
+
+	router.Use(NewMiddleware(mockMonitor, mockLogger).ResponseTime())
+	router.Get("/api/items/{item_id}", new(API).test)
+	router.Get("/api/check/*", new(API).test)
+	router.NotFound(new(API).test)
+
+	for _, path := range []string{"/api/items/42", "/api/check/orders/1", "/anything/else"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+}
@@ -37,3 +37,7 @@ func (m *NoopMonitor) SetDependencyAvailability(map[string]string, float64) erro
 func (m *NoopMonitor) GetResponseTimeMetric(tags map[string]string) (MetricInterface, error) {
 	return new(NoopMetricInterface), nil
 }
+
+func (m *NoopMonitor) GetPolicyEvaluationMetric(tags map[string]string) (MetricInterface, error) {
+	return new(NoopMetricInterface), nil
+}
@@ -15,7 +15,8 @@ import (
 type Monitor struct {
 	service string
 
-	responseTime *prometheus.HistogramVec
+	responseTime     *prometheus.HistogramVec
+	policyEvaluation *prometheus.HistogramVec
 
 	logger logging.LoggerInterface
 }
@@ -32,6 +33,14 @@ func (m *Monitor) GetResponseTimeMetric(tags map[string]string) (monitoring.Metr
 	return m.responseTime.With(tags), nil
 }
 
+func (m *Monitor) GetPolicyEvaluationMetric(tags map[string]string) (monitoring.MetricInterface, error) {
+	if m.policyEvaluation == nil {
+		return nil, fmt.Errorf("metric not instantiated")
+	}
+
+	return m.policyEvaluation.With(tags), nil
+}
+
 func (m *Monitor) registerHistograms() {
 	histograms := make([]*prometheus.HistogramVec, 0)
 
@@ -45,17 +54,27 @@ func (m *Monitor) registerHistograms() {
 			Help:        "http_response_time_seconds",
 			ConstLabels: labels,
 		},
-		[]string{"route", "status"},
+		[]string{"route", "status", monitoring.TenantLabel},
+	)
+
+	m.policyEvaluation = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "policy_evaluation_seconds",
+			Help:        "policy_evaluation_seconds",
+			ConstLabels: labels,
+			Buckets:     []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+		},
+		[]string{"decision", "result"},
 	)
 
-	histograms = append(histograms, m.responseTime)
+	histograms = append(histograms, m.responseTime, m.policyEvaluation)
 
 	for _, histogram := range histograms {
 		err := prometheus.Register(histogram)
 
 		switch err.(type) {
 		case nil:
-			return
+			continue
 		case prometheus.AlreadyRegisteredError:
 			m.logger.Debugf("metric %v already registered", histogram)
 		default:
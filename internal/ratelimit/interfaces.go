@@ -0,0 +1,12 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package ratelimit
+
+import (
+	"context"
+)
+
+type LimiterInterface interface {
+	Allow(context.Context, string, Limit) (*Result, error)
+}
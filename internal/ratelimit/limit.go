@@ -0,0 +1,58 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket, Requests tokens are added every Period up to Burst
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+
+	return float64(l.Requests)
+}
+
+// rate is expressed in tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of a single rate limit check
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	// Reset is the time needed for the bucket to be full again
+	Reset time.Duration
+}
+
+func newResult(l Limit, tokens float64, allowed bool) *Result {
+	r := new(Result)
+
+	r.Allowed = allowed
+	r.Limit = int(l.capacity())
+	r.Remaining = int(math.Floor(tokens))
+	r.Reset = seconds((l.capacity() - tokens) / l.rate())
+
+	if !allowed {
+		r.RetryAfter = seconds((1 - tokens) / l.rate())
+	}
+
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const idleSweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// Local keeps token buckets in process memory, limits are enforced per replica
+type Local struct {
+	buckets map[string]*bucket
+
+	mu sync.Mutex
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *Local) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	b, ok := l.buckets[key]
+
+	if !ok {
+		b = &bucket{tokens: limit.capacity(), last: now}
+		l.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.tokens = math.Min(limit.capacity(), b.tokens+now.Sub(b.last).Seconds()*limit.rate())
+	b.last = now
+
+	if b.tokens < 1 {
+		return newResult(limit, b.tokens, false), nil
+	}
+
+	b.tokens--
+
+	return newResult(limit, b.tokens, true), nil
+}
+
+// Close stops the background sweep of idle buckets
+func (l *Local) Close() {
+	l.closeOnce.Do(func() { close(l.stop) })
+}
+
+func (l *Local) sweep() {
+	ticker := time.NewTicker(idleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			l.removeIdle(now)
+		}
+	}
+}
+
+// removeIdle drops the buckets that had time to refill completely, they are equivalent to new ones
+func (l *Local) removeIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last).Seconds()*b.limit.rate() >= b.limit.capacity() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func NewLocal() *Local {
+	l := new(Local)
+
+	l.buckets = make(map[string]*bucket)
+	l.stop = make(chan struct{})
+
+	go l.sweep()
+
+	return l
+}
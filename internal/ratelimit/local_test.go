@@ -0,0 +1,72 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalAllowBurstThenDeny(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewLocal()
+	limit := Limit{Requests: 1, Period: time.Hour, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		res, err := l.Allow(context.TODO(), "key", limit)
+		assert.Nil(err)
+		assert.True(res.Allowed, "request %d should be allowed", i)
+		assert.Equal(2, res.Limit)
+	}
+
+	res, err := l.Allow(context.TODO(), "key", limit)
+	assert.Nil(err)
+	assert.False(res.Allowed)
+	assert.Equal(0, res.Remaining)
+	assert.True(res.RetryAfter > 0, "retry after should be set")
+}
+
+func TestLocalKeysAreIndependent(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewLocal()
+	limit := Limit{Requests: 1, Period: time.Hour}
+
+	res, _ := l.Allow(context.TODO(), "a", limit)
+	assert.True(res.Allowed)
+
+	res, _ = l.Allow(context.TODO(), "b", limit)
+	assert.True(res.Allowed)
+
+	res, _ = l.Allow(context.TODO(), "a", limit)
+	assert.False(res.Allowed)
+}
+
+func TestLocalSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewLocal()
+	defer l.Close()
+
+	_, _ = l.Allow(context.TODO(), "idle", Limit{Requests: 1, Period: time.Second})
+	_, _ = l.Allow(context.TODO(), "busy", Limit{Requests: 1, Period: time.Hour})
+
+	l.removeIdle(time.Now().Add(time.Minute))
+
+	assert.Len(l.buckets, 1)
+	assert.Contains(l.buckets, "busy")
+}
+
+func TestLocalClose(t *testing.T) {
+	l := NewLocal()
+
+	assert.NotPanics(t, func() {
+		l.Close()
+		l.Close()
+	})
+}
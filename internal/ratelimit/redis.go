@@ -0,0 +1,69 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "iam-ext-authz:ratelimit:"
+
+// tokenBucket refills and consumes the bucket atomically, redis server time is used
+// so that replicas with drifting clocks agree on the state
+var tokenBucket = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or capacity
+local last = tonumber(state[2]) or now
+
+tokens = math.min(capacity, tokens + math.max(0, now - last) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate * 1000))
+
+return {allowed, tostring(tokens)}
+`)
+
+// Redis keeps token buckets on the shared redis cache backend, limits are enforced across replicas
+type Redis struct {
+	client redis.Scripter
+}
+
+func (l *Redis) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	res, err := tokenBucket.Run(ctx, l.client, []string{keyPrefix + key}, limit.capacity(), limit.rate()).Slice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, _ := res[0].(int64)
+	raw, _ := res[1].(string)
+
+	// a malformed value means an empty bucket, the request outcome is still valid
+	tokens, _ := strconv.ParseFloat(raw, 64)
+
+	return newResult(limit, tokens, allowed == 1), nil
+}
+
+func NewRedis(client redis.Scripter) *Redis {
+	l := new(Redis)
+
+	l.client = client
+
+	return l
+}
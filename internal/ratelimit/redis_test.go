@@ -0,0 +1,79 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedis(client), server
+}
+
+func TestRedisAllowBurstThenDeny(t *testing.T) {
+	assert := assert.New(t)
+
+	l, server := newTestRedis(t)
+	limit := Limit{Requests: 1, Period: time.Hour, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		res, err := l.Allow(context.TODO(), "key", limit)
+		assert.Nil(err)
+		assert.True(res.Allowed, "request %d should be allowed", i)
+		assert.Equal(2, res.Limit)
+		assert.Equal(1-i, res.Remaining)
+	}
+
+	res, err := l.Allow(context.TODO(), "key", limit)
+	assert.Nil(err)
+	assert.False(res.Allowed)
+	assert.Equal(0, res.Remaining)
+	assert.True(res.RetryAfter > 0, "retry after should be set")
+
+	// buckets expire once they had time to refill completely
+	assert.True(server.Exists(keyPrefix + "key"))
+	assert.Equal(2*time.Hour, server.TTL(keyPrefix+"key"))
+}
+
+func TestRedisRefill(t *testing.T) {
+	assert := assert.New(t)
+
+	l, server := newTestRedis(t)
+	limit := Limit{Requests: 1, Period: time.Minute}
+
+	res, _ := l.Allow(context.TODO(), "key", limit)
+	assert.True(res.Allowed)
+
+	res, _ = l.Allow(context.TODO(), "key", limit)
+	assert.False(res.Allowed)
+
+	// the script reads the redis server clock
+	server.SetTime(time.Now().Add(time.Minute))
+
+	res, _ = l.Allow(context.TODO(), "key", limit)
+	assert.True(res.Allowed)
+
+	res, _ = l.Allow(context.TODO(), "other", limit)
+	assert.True(res.Allowed, "keys are independent")
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	l, server := newTestRedis(t)
+	server.Close()
+
+	_, err := l.Allow(context.TODO(), "key", Limit{Requests: 1, Period: time.Minute})
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrExpiredKey = errors.New("expired API key")
+)
+
+// argon2Backoff is how long verifications of an argon2id key are refused after a failed one,
+// presenting wrong secrets for a known id can't burn more than one hash per key and backoff
+const argon2Backoff = time.Second
+
+// Key is a single entry of the keys file, Hash is either `sha256:<hex>` or an argon2id PHC
+// string; argon2id keys need to be presented as `<id>.<secret>` so that only one hash is tried
+type Key struct {
+	ID        string     `yaml:"id"`
+	Hash      string     `yaml:"hash"`
+	Subject   string     `yaml:"subject"`
+	Scopes    []string   `yaml:"scopes"`
+	ExpiresAt *time.Time `yaml:"expires_at"`
+
+	argon2 *argon2Hash
+	// mu serializes the argon2id verifications of the key
+	mu          sync.Mutex
+	nextAttempt time.Time
+}
+
+type keysFile struct {
+	Keys []Key `yaml:"keys"`
+}
+
+type keySet struct {
+	byID     map[string]*Key
+	bySHA256 map[string]*Key
+	// verified remembers argon2id matches, the hash is expensive by design; only the secret of
+	// a key can match so it never holds more entries than keys
+	verified   map[string]*Key
+	verifiedMu sync.RWMutex
+}
+
+// Store holds the API keys loaded from a file, the file is polled and reloaded on change
+type Store struct {
+	path string
+	raw  []byte
+
+	keys *keySet
+	mu   sync.RWMutex
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// Authenticate returns the key entry matching the presented API key
+func (s *Store) Authenticate(ctx context.Context, presented string) (*Key, error) {
+	_, span := s.tracer.Start(ctx, "apikey.Store.Authenticate")
+	defer span.End()
+
+	s.mu.RLock()
+	keys := s.keys
+	s.mu.RUnlock()
+
+	digest := sha256Hex(presented)
+
+	key, ok := keys.bySHA256[digest]
+
+	if !ok {
+		key = keys.argon2Match(presented, digest)
+	}
+
+	if key == nil {
+		return nil, ErrInvalidKey
+	}
+
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+
+	return key, nil
+}
+
+func (k *keySet) argon2Match(presented, digest string) *Key {
+	k.verifiedMu.RLock()
+	key, ok := k.verified[digest]
+	k.verifiedMu.RUnlock()
+
+	if ok {
+		return key
+	}
+
+	id, _, found := strings.Cut(presented, ".")
+
+	if !found {
+		return nil
+	}
+
+	key, ok = k.byID[id]
+
+	if !ok || key.argon2 == nil || !key.verify(presented) {
+		return nil
+	}
+
+	k.verifiedMu.Lock()
+	defer k.verifiedMu.Unlock()
+
+	if len(k.verified) < len(k.byID) {
+		k.verified[digest] = key
+	}
+
+	return key
+}
+
+// verify checks the secret against the argon2id hash of the key, one verification at a time
+// and none during the backoff following a failure
+func (key *Key) verify(presented string) bool {
+	key.mu.Lock()
+	defer key.mu.Unlock()
+
+	if time.Now().Before(key.nextAttempt) {
+		return false
+	}
+
+	if key.argon2.verify(presented) {
+		return true
+	}
+
+	key.nextAttempt = time.Now().Add(argon2Backoff)
+
+	return false
+}
+
+// Reload reads the keys file again, the current keys are kept if the new file is invalid
+func (s *Store) Reload() error {
+	raw, err := os.ReadFile(s.path)
+
+	if err != nil {
+		return fmt.Errorf("unable to read API keys: %w", err)
+	}
+
+	s.mu.RLock()
+	unchanged := s.keys != nil && bytes.Equal(raw, s.raw)
+	s.mu.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	keys, err := parseKeys(raw)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.raw = raw
+	s.keys = keys
+	s.mu.Unlock()
+
+	s.logger.Infof("loaded %d API keys from %s", len(keys.byID), s.path)
+
+	return nil
+}
+
+// Watch polls the keys file, kubernetes secret mounts swap symlinks so the content is compared
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				s.logger.Errorf("API keys reload failed, keeping current keys: %v", err)
+			}
+		}
+	}
+}
+
+func parseKeys(raw []byte) (*keySet, error) {
+	f := new(keysFile)
+
+	if err := yaml.Unmarshal(raw, f); err != nil {
+		return nil, fmt.Errorf("unable to parse API keys: %w", err)
+	}
+
+	keys := new(keySet)
+	keys.byID = make(map[string]*Key)
+	keys.bySHA256 = make(map[string]*Key)
+	keys.verified = make(map[string]*Key)
+
+	for i := range f.Keys {
+		key := &f.Keys[i]
+
+		if key.ID == "" || key.Subject == "" {
+			return nil, fmt.Errorf("API key %d: id and subject are required", i)
+		}
+
+		if _, ok := keys.byID[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate API key %s", key.ID)
+		}
+
+		switch {
+		case strings.HasPrefix(key.Hash, sha256Prefix):
+			keys.bySHA256[strings.ToLower(strings.TrimPrefix(key.Hash, sha256Prefix))] = key
+		case strings.HasPrefix(key.Hash, argon2idPrefix):
+			h, err := parseArgon2(key.Hash)
+
+			if err != nil {
+				return nil, fmt.Errorf("API key %s: %w", key.ID, err)
+			}
+
+			key.argon2 = h
+		default:
+			return nil, fmt.Errorf("API key %s: unsupported hash format", key.ID)
+		}
+
+		keys.byID[key.ID] = key
+	}
+
+	return keys, nil
+}
+
+func NewStore(path string, tracer tracing.TracingInterface, logger logging.LoggerInterface) (*Store, error) {
+	s := new(Store)
+
+	s.path = path
+
+	s.tracer = tracer
+	s.logger = logger
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package apikey
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/argon2"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+func writeKeys(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+}
+
+func TestStoreAuthenticate(t *testing.T) {
+	assert := assert.New(t)
+
+	salt := []byte("0123456789abcdef")
+	hash := argon2.IDKey([]byte("ci.secret"), salt, 1, 1024, 1, 32)
+	phc := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys(t, path, fmt.Sprintf(`
+keys:
+  - id: webhooks
+    hash: sha256:%s
+    subject: webhooks
+    scopes: ["hooks:write"]
+  - id: ci
+    hash: "%s"
+    subject: ci-runner
+  - id: old
+    hash: sha256:%s
+    subject: old
+    expires_at: 2020-01-01T00:00:00Z
+`, sha256Hex("plain-secret"), phc, sha256Hex("old-secret")))
+
+	s, err := NewStore(path, tracing.NewNoopTracer(), logging.NewNoopLogger())
+	assert.Nil(err)
+
+	key, err := s.Authenticate(context.TODO(), "plain-secret")
+	assert.Nil(err)
+	assert.Equal("webhooks", key.Subject)
+	assert.Equal([]string{"hooks:write"}, key.Scopes)
+
+	key, err = s.Authenticate(context.TODO(), "ci.secret")
+	assert.Nil(err)
+	assert.Equal("ci-runner", key.Subject)
+
+	_, err = s.Authenticate(context.TODO(), "ci.wrong")
+	assert.ErrorIs(err, ErrInvalidKey)
+
+	_, err = s.Authenticate(context.TODO(), "old-secret")
+	assert.ErrorIs(err, ErrExpiredKey)
+}
+
+func TestStoreArgon2Backoff(t *testing.T) {
+	assert := assert.New(t)
+
+	salt := []byte("0123456789abcdef")
+	hash := argon2.IDKey([]byte("ci.secret"), salt, 1, 1024, 1, 32)
+	phc := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys(t, path, fmt.Sprintf("keys: [{id: ci, subject: ci-runner, hash: '%s'}]", phc))
+
+	s, err := NewStore(path, tracing.NewNoopTracer(), logging.NewNoopLogger())
+	assert.Nil(err)
+
+	_, err = s.Authenticate(context.TODO(), "ci.wrong")
+	assert.ErrorIs(err, ErrInvalidKey)
+
+	_, err = s.Authenticate(context.TODO(), "ci.secret")
+	assert.ErrorIs(err, ErrInvalidKey, "no hash is computed during the backoff")
+
+	s.keys.byID["ci"].nextAttempt = time.Time{}
+
+	_, err = s.Authenticate(context.TODO(), "ci.secret")
+	assert.Nil(err)
+
+	for i := 0; i < 3; i++ {
+		_, _ = s.Authenticate(context.TODO(), "ci.secret")
+	}
+
+	assert.Len(s.keys.verified, 1)
+}
+
+func TestStoreReload(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeKeys(t, path, fmt.Sprintf("keys: [{id: a, subject: a, hash: 'sha256:%s'}]", sha256Hex("a-secret")))
+
+	s, err := NewStore(path, tracing.NewNoopTracer(), logging.NewNoopLogger())
+	assert.Nil(err)
+
+	writeKeys(t, path, fmt.Sprintf("keys: [{id: b, subject: b, hash: 'sha256:%s'}]", sha256Hex("b-secret")))
+	assert.Nil(s.Reload())
+
+	_, err = s.Authenticate(context.TODO(), "a-secret")
+	assert.ErrorIs(err, ErrInvalidKey)
+
+	key, err := s.Authenticate(context.TODO(), "b-secret")
+	assert.Nil(err)
+	assert.Equal("b", key.Subject)
+
+	writeKeys(t, path, "keys: [{id: broken}]")
+	assert.NotNil(s.Reload())
+
+	_, err = s.Authenticate(context.TODO(), "b-secret")
+	assert.Nil(err, "invalid file should keep the previous keys")
+}
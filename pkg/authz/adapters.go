@@ -0,0 +1,146 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"net/http"
+	"net/url"
+)
+
+const (
+	traefikCheckPath = "/api/v0/traefik/check"
+	nginxCheckPath   = "/api/v0/nginx/check"
+)
+
+// proxyAdapters maps the check paths of proxies other than envoy to their adapter, the
+// forwarded headers describing the original request are only read on these paths
+var proxyAdapters = map[string]ProxyAdapterInterface{
+	traefikCheckPath: new(TraefikAdapter),
+	nginxCheckPath:   new(NginxAdapter),
+}
+
+// ProxyAdapterInterface translates between the check handler and the forward auth conventions
+// of a proxy other than envoy
+type ProxyAdapterInterface interface {
+	// Original returns the request being authorized, read from the headers set by the proxy
+	Original(*http.Request) *Original
+	// Status maps the status written by the check to one the proxy acts upon
+	Status(status int, allowed bool) int
+}
+
+// TraefikAdapter handles traefik ForwardAuth, which sends X-Forwarded-Method, X-Forwarded-Uri
+// and X-Forwarded-Host and returns any non 2xx response, redirects included, to the client
+type TraefikAdapter struct{}
+
+func (a *TraefikAdapter) Original(r *http.Request) *Original {
+	o := new(Original)
+	o.Method = r.Header.Get("X-Forwarded-Method")
+	o.Host = r.Header.Get("X-Forwarded-Host")
+	o.URI = r.Header.Get("X-Forwarded-Uri")
+	o.Scheme = r.Header.Get("X-Forwarded-Proto")
+
+	if o.Method == "" {
+		o.Method = r.Method
+	}
+
+	if o.Host == "" {
+		o.Host = r.Host
+	}
+
+	if o.URI == "" {
+		o.URI = "/"
+	}
+
+	return o
+}
+
+// Status turns challenges answered with a 2xx, as the kratos login flow, into a 401 as
+// traefik would let the request through otherwise
+func (a *TraefikAdapter) Status(status int, allowed bool) int {
+	if status < http.StatusMultipleChoices && !allowed {
+		return http.StatusUnauthorized
+	}
+
+	return status
+}
+
+// NginxAdapter handles nginx auth_request and ingress-nginx external auth, which send the
+// original request in X-Original-URL (or X-Original-URI) and X-Original-Method and only
+// understand 2xx, 401 and 403
+type NginxAdapter struct{}
+
+func (a *NginxAdapter) Original(r *http.Request) *Original {
+	o := new(Original)
+	o.Method = r.Header.Get("X-Original-Method")
+	o.Host = r.Header.Get("X-Forwarded-Host")
+	o.URI = r.Header.Get("X-Original-URI")
+	o.Scheme = r.Header.Get("X-Forwarded-Proto")
+
+	if u, err := url.Parse(r.Header.Get("X-Original-URL")); err == nil && u.Host != "" {
+		o.Host = u.Host
+		o.URI = u.RequestURI()
+		o.Scheme = u.Scheme
+	}
+
+	if o.Method == "" {
+		o.Method = r.Method
+	}
+
+	if o.Host == "" {
+		o.Host = r.Host
+	}
+
+	if o.URI == "" {
+		o.URI = "/"
+	}
+
+	return o
+}
+
+// Status leaves 401 to trigger the auth-signin redirect of ingress-nginx, other denials become
+// a 403 as nginx turns any other status in a 500
+func (a *NginxAdapter) Status(status int, allowed bool) int {
+	switch {
+	case status < http.StatusMultipleChoices && allowed:
+		return status
+	case status < http.StatusBadRequest, status == http.StatusUnauthorized:
+		return http.StatusUnauthorized
+	case status >= http.StatusInternalServerError:
+		return status
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// adaptedWriter rewrites the status of the check response for the proxy
+type adaptedWriter struct {
+	http.ResponseWriter
+
+	adapter     ProxyAdapterInterface
+	wroteHeader bool
+}
+
+func (w *adaptedWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.adapter.Status(status, w.Header().Get(resultHeader) == resultAllowed))
+}
+
+func (w *adaptedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// adapt serves the check through the adapter of the proxy
+func (a *API) adapt(adapter ProxyAdapterInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.check(&adaptedWriter{ResponseWriter: w, adapter: adapter}, r)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+type fakeChallenger struct {
+	fakeAuthenticator
+
+	status int
+}
+
+func (f *fakeChallenger) Challenge(w http.ResponseWriter, r *http.Request) {
+	if f.status == http.StatusFound {
+		w.Header().Set("Location", "https://login.example.com")
+	}
+
+	w.WriteHeader(f.status)
+}
+
+func TestProxyAdapters(t *testing.T) {
+	set := []policy.Policy{
+		{Name: "orders", Match: policy.Match{Hosts: []string{"api.example.com"}, PathPrefix: "/orders", Methods: []string{"POST"}}, Authenticators: []string{policy.AuthenticatorAPIKey}},
+		{Name: "ui", Match: policy.Match{Hosts: []string{"app.example.com"}}, Authenticators: []string{policy.AuthenticatorKratosCookie}},
+		{Name: "login", Match: policy.Match{Hosts: []string{"sso.example.com"}}, Authenticators: []string{policy.AuthenticatorRelyingParty}},
+		{Name: "default", Authenticators: []string{policy.AuthenticatorKratosCookie}},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		host    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "envoy allowed", method: http.MethodPost, path: "/api/v0/check/orders/1?x=1", host: "api.example.com", status: http.StatusOK},
+		{name: "envoy ignores forwarded headers", method: http.MethodGet, path: "/api/v0/check/", host: "app.example.com", headers: map[string]string{"X-Forwarded-Method": "POST", "X-Forwarded-Host": "api.example.com", "X-Forwarded-Uri": "/orders/1"}, status: http.StatusUnauthorized},
+		{name: "traefik allowed", path: traefikCheckPath, headers: map[string]string{"X-Forwarded-Method": "POST", "X-Forwarded-Host": "api.example.com", "X-Forwarded-Uri": "/orders/1?x=1"}, status: http.StatusOK},
+		{name: "traefik wrong method", path: traefikCheckPath, headers: map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Host": "api.example.com", "X-Forwarded-Uri": "/orders/1"}, status: http.StatusUnauthorized},
+		{name: "traefik login flow", path: traefikCheckPath, headers: map[string]string{"X-Forwarded-Host": "app.example.com", "X-Forwarded-Uri": "/"}, status: http.StatusUnauthorized},
+		{name: "traefik redirect", path: traefikCheckPath, headers: map[string]string{"X-Forwarded-Host": "sso.example.com", "X-Forwarded-Uri": "/"}, status: http.StatusFound},
+		{name: "nginx allowed", path: nginxCheckPath, headers: map[string]string{"X-Original-Method": "POST", "X-Original-URL": "https://api.example.com/orders/1?x=1"}, status: http.StatusOK},
+		{name: "nginx wrong method", path: nginxCheckPath, headers: map[string]string{"X-Original-Method": "GET", "X-Original-URL": "https://api.example.com/orders/1"}, status: http.StatusUnauthorized},
+		{name: "nginx redirect", path: nginxCheckPath, headers: map[string]string{"X-Original-URL": "https://sso.example.com/"}, status: http.StatusUnauthorized},
+		{name: "nginx rate limited", path: nginxCheckPath, headers: map[string]string{"X-Original-Method": "POST", "X-Original-URL": "https://api.example.com/orders/1", "X-Limit": "true"}, status: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			apiKey := &fakeAuthenticator{name: policy.AuthenticatorAPIKey, identity: &Identity{Subject: "ci"}}
+
+			if test.headers["X-Limit"] != "" {
+				apiKey.identity = nil
+				apiKey.err = authError(http.StatusTooManyRequests, "", nil)
+			}
+
+			a := newChainAPI(
+				policy.Policy{},
+				apiKey,
+				&fakeChallenger{fakeAuthenticator: fakeAuthenticator{name: policy.AuthenticatorKratosCookie, err: ErrNoCredentials}, status: http.StatusOK},
+				&fakeChallenger{fakeAuthenticator: fakeAuthenticator{name: policy.AuthenticatorRelyingParty, err: ErrNoCredentials}, status: http.StatusFound},
+			)
+			a.policies = policy.NewStore(&policy.Set{Policies: set}, "test")
+
+			mux := chi.NewMux()
+			a.RegisterEndpoints(mux)
+
+			method := test.method
+
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, test.path, nil)
+
+			if test.host != "" {
+				r.Host = test.host
+			}
+
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(test.status, w.Code)
+
+			if test.status == http.StatusOK {
+				assert.Equal("ci", w.Header().Get(kubeflowHeader))
+			}
+		})
+	}
+}
+
+func TestChallengeIsNeverAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge int
+		err       error
+		status    int
+	}{
+		{"login flow without credentials", http.StatusOK, ErrNoCredentials, http.StatusUnauthorized},
+		{"login flow on expired session", http.StatusOK, authError(http.StatusUnauthorized, "", nil), http.StatusUnauthorized},
+		{"redirect", http.StatusFound, ErrNoCredentials, http.StatusFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			a := newChainAPI(
+				policy.Policy{Name: "ui", Authenticators: []string{policy.AuthenticatorKratosCookie}},
+				&fakeChallenger{fakeAuthenticator: fakeAuthenticator{name: policy.AuthenticatorKratosCookie, err: test.err}, status: test.challenge},
+			)
+
+			mux := chi.NewMux()
+			a.RegisterEndpoints(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/check", nil))
+
+			assert.Equal(test.status, w.Code)
+			assert.Equal(resultDenied, w.Header().Get(resultHeader))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// apiKeyCredential extracts the API key from the header or query parameter configured on the policy
+func apiKeyCredential(r *http.Request, p *policy.Policy) (string, bool) {
+	if p == nil || p.APIKey == nil {
+		return "", false
+	}
+
+	if p.APIKey.Header != "" {
+		if key := strings.TrimSpace(r.Header.Get(p.APIKey.Header)); key != "" {
+			return key, true
+		}
+	}
+
+	if p.APIKey.Query != "" {
+		_, rawQuery, _ := strings.Cut(originalURI(r), "?")
+
+		if q, err := url.ParseQuery(rawQuery); err == nil && q.Get(p.APIKey.Query) != "" {
+			return q.Get(p.APIKey.Query), true
+		}
+	}
+
+	return "", false
+}
+
+// APIKeyAuthenticator validates static API keys read from the location set on the policy
+type APIKeyAuthenticator struct {
+	keys APIKeyStoreInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *APIKeyAuthenticator) Name() string {
+	return policy.AuthenticatorAPIKey
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	presented, found := apiKeyCredential(r, p)
+
+	if !found {
+		return nil, ErrNoCredentials
+	}
+
+	if a.keys == nil {
+		return nil, authError(http.StatusUnauthorized, "", fmt.Errorf("policy %s accepts API keys but no keys are configured", p.Name))
+	}
+
+	key, err := a.keys.Authenticate(r.Context(), presented)
+
+	if err != nil {
+		return nil, authError(http.StatusUnauthorized, "", err)
+	}
+
+	identity := new(Identity)
+	identity.Subject = key.Subject
+	identity.ClientID = key.ID
+	identity.Scopes = key.Scopes
+	identity.Authenticator = policy.AuthenticatorAPIKey
+
+	return identity, nil
+}
+
+func NewAPIKeyAuthenticator(keys APIKeyStoreInterface, logger logging.LoggerInterface) *APIKeyAuthenticator {
+	a := new(APIKeyAuthenticator)
+
+	a.keys = keys
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,300 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	hClient "github.com/ory/hydra-client-go/v2"
+	kClient "github.com/ory/kratos-client-go"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/xfcc"
+)
+
+// ErrNoCredentials is returned by authenticators when the request doesn't carry the credentials
+// they handle, the chain moves on to the next authenticator
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the outcome of a successful authentication
+type Identity struct {
+	Subject string
+	// Username is sent upstream in place of the subject when set
+	Username string
+	ClientID string
+	Scopes   []string
+	// Groups are the groups mapped from the token of a trusted issuer
+	Groups []string
+	Claims map[string]interface{}
+	// Authenticator is the name of the authenticator that produced the identity
+	Authenticator string
+	// Token is the access token the identity comes from, used for token exchange
+	Token string
+	// Session and Introspection are the raw kratos session and introspection result, when
+	// the identity comes from them
+	Session       *kClient.Session
+	Introspection *hClient.IntrospectedOAuth2Token
+	// Cookies are set on the client when the request is allowed, as kratos does after
+	// extending a session
+	Cookies []*http.Cookie
+}
+
+// Header returns the value of the identity header sent upstream
+func (i *Identity) Header() string {
+	if i.Username != "" {
+		return i.Username
+	}
+
+	return i.Subject
+}
+
+// Attribute returns an identity attribute as used in identity header mappings: subject,
+// username, client_id, groups (comma separated) or claims.<path> for a dot separated path into the claims
+func (i *Identity) Attribute(name string) string {
+	switch name {
+	case "subject":
+		return i.Subject
+	case "username":
+		return i.Header()
+	case "client_id":
+		return i.ClientID
+	case "groups":
+		return strings.Join(i.Groups, ",")
+	}
+
+	path, found := strings.CutPrefix(name, "claims.")
+
+	if !found {
+		return ""
+	}
+
+	var current interface{} = i.Claims
+
+	for _, key := range strings.Split(path, ".") {
+		claims, ok := current.(map[string]interface{})
+
+		if !ok {
+			return ""
+		}
+
+		current = claims[key]
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// AuthError is returned by authenticators when credentials are present but not acceptable,
+// it carries the response to send back
+type AuthError struct {
+	// Authenticator is the name of the authenticator that failed, set by the chain
+	Authenticator string
+	Status        int
+	Challenge     string
+	Err           error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%d: %v", e.Status, e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func authError(status int, challenge string, err error) *AuthError {
+	e := new(AuthError)
+
+	e.Status = status
+	e.Challenge = challenge
+	e.Err = err
+
+	return e
+}
+
+// authenticate runs the authenticator chain of the policy, in first mode the first authenticator
+// finding credentials decides, in all mode every authenticator has to succeed and the first
+// identity is returned; a nil identity and error means no credentials were found
+func (a *API) authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	var identity *Identity
+
+	for _, name := range a.chain(p) {
+		authenticator, ok := a.authenticators[name]
+
+		// the default chain lists every authenticator, only the configured ones run, a chain
+		// picked by the policy or matching all fails closed on a missing one
+		if !ok && (p.EvaluationMode() == policy.ChainAll || len(p.Authenticators) > 0) {
+			authErr := authError(http.StatusInternalServerError, "", fmt.Errorf("authenticator %s is not configured", name))
+			authErr.Authenticator = name
+
+			return nil, authErr
+		}
+
+		if !ok {
+			continue
+		}
+
+		id, err := authenticator.Authenticate(r, p)
+
+		if p.EvaluationMode() == policy.ChainAll && errors.Is(err, ErrNoCredentials) {
+			err = authError(http.StatusUnauthorized, "", err)
+		}
+
+		authErr := new(AuthError)
+
+		if errors.As(err, &authErr) {
+			authErr.Authenticator = name
+		}
+
+		if p.EvaluationMode() == policy.ChainAll {
+			if err != nil {
+				return nil, err
+			}
+
+			identity = merge(identity, id)
+
+			continue
+		}
+
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+
+		return id, err
+	}
+
+	return identity, nil
+}
+
+// chain returns the configured authenticators of the policy, the default chain only lists the
+// relying party when the mode is enabled, otherwise the kratos cookie is used as before
+func (a *API) chain(p *policy.Policy) []string {
+	chain := p.Chain()
+
+	if p == nil || len(p.Authenticators) > 0 || !p.RelyingParty {
+		return chain
+	}
+
+	if _, ok := a.authenticators[policy.AuthenticatorRelyingParty]; ok {
+		return chain
+	}
+
+	return append(chain[:len(chain)-1], policy.AuthenticatorKratosCookie)
+}
+
+// challenger returns the first authenticator of the chain able to start an interactive login
+func (a *API) challenger(p *policy.Policy) ChallengerInterface {
+	for _, name := range a.chain(p) {
+		if c, ok := a.authenticators[name].(ChallengerInterface); ok {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func merge(identity, other *Identity) *Identity {
+	if identity == nil {
+		return other
+	}
+
+	if identity.ClientID == "" {
+		identity.ClientID = other.ClientID
+	}
+
+	if identity.Token == "" {
+		identity.Token = other.Token
+	}
+
+	identity.Scopes = append(identity.Scopes, other.Scopes...)
+	identity.Cookies = append(identity.Cookies, other.Cookies...)
+
+	return identity
+}
+
+// AnonymousAuthenticator always succeeds with an empty identity, meant to be last in the chain
+// of public routes
+type AnonymousAuthenticator struct{}
+
+func (a *AnonymousAuthenticator) Name() string {
+	return policy.AuthenticatorAnonymous
+}
+
+func (a *AnonymousAuthenticator) Authenticate(*http.Request, *policy.Policy) (*Identity, error) {
+	return &Identity{Authenticator: policy.AuthenticatorAnonymous}, nil
+}
+
+func NewAnonymousAuthenticator() *AnonymousAuthenticator {
+	return new(AnonymousAuthenticator)
+}
+
+// ClientCertificateAuthenticator authenticates service to service calls on workload routes by
+// the forwarded client certificate
+type ClientCertificateAuthenticator struct {
+	logger logging.LoggerInterface
+}
+
+func (a *ClientCertificateAuthenticator) Name() string {
+	return policy.AuthenticatorClientCertificate
+}
+
+func (a *ClientCertificateAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	cert := clientCertificate(r)
+
+	if p == nil || len(p.Workloads) == 0 || cert == nil {
+		return nil, ErrNoCredentials
+	}
+
+	if err := allowedWorkload(p, cert); err != nil {
+		return nil, err
+	}
+
+	return &Identity{Subject: workloadIdentity(cert), Authenticator: policy.AuthenticatorClientCertificate}, nil
+}
+
+func NewClientCertificateAuthenticator(logger logging.LoggerInterface) *ClientCertificateAuthenticator {
+	a := new(ClientCertificateAuthenticator)
+
+	a.logger = logger
+
+	return a
+}
+
+type clientCertificateKey struct{}
+
+// withClientCertificate parses the forwarded client certificate once per check, only the element
+// added by the trusted proxy is used and the header is ignored when none is configured, malformed
+// headers are ignored
+func withClientCertificate(r *http.Request, by string, logger logging.LoggerInterface) *http.Request {
+	cert, err := xfcc.Parse(r.Header.Get(xfcc.Header), by)
+
+	if err != nil {
+		logging.FromContext(r.Context(), logger).Infof("ignoring malformed %s header: %v", xfcc.Header, err)
+		return r
+	}
+
+	if cert == nil {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), clientCertificateKey{}, cert))
+}
+
+// clientCertificate returns the client certificate forwarded by the trusted proxy, nil without one
+func clientCertificate(r *http.Request) *xfcc.Certificate {
+	cert, _ := r.Context().Value(clientCertificateKey{}).(*xfcc.Certificate)
+
+	return cert
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+type fakeAuthenticator struct {
+	name     string
+	identity *Identity
+	err      error
+	calls    int
+}
+
+func (f *fakeAuthenticator) Name() string {
+	return f.name
+}
+
+func (f *fakeAuthenticator) Authenticate(*http.Request, *policy.Policy) (*Identity, error) {
+	f.calls++
+
+	return f.identity, f.err
+}
+
+func newChainAPI(p policy.Policy, authenticators ...AuthenticatorInterface) *API {
+	return NewAPI(policy.NewStore(&policy.Set{Policies: []policy.Policy{p}}, "test"), authenticators, &Config{Limiter: ratelimit.NewLocal(), MaxBodyBytes: 1024}, logging.NewNoopLogger())
+}
+
+func TestChainFirstSkipsMissingCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	jwt := &fakeAuthenticator{name: policy.AuthenticatorJWT, err: ErrNoCredentials}
+	apiKey := &fakeAuthenticator{name: policy.AuthenticatorAPIKey, identity: &Identity{Subject: "ci"}}
+	anonymous := &fakeAuthenticator{name: policy.AuthenticatorAnonymous, identity: &Identity{}}
+
+	a := newChainAPI(
+		policy.Policy{Name: "api", Authenticators: []string{policy.AuthenticatorJWT, policy.AuthenticatorAPIKey, policy.AuthenticatorAnonymous}},
+		jwt, apiKey, anonymous,
+	)
+
+	w := httptest.NewRecorder()
+	a.check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check", nil))
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("ci", w.Header().Get(kubeflowHeader))
+	assert.Equal(1, jwt.calls)
+	assert.Equal(0, anonymous.calls)
+}
+
+func TestChainFirstStopsAtInvalidCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	jwt := &fakeAuthenticator{name: policy.AuthenticatorJWT, err: authError(http.StatusUnauthorized, `Bearer error="invalid_token"`, fmt.Errorf("expired"))}
+	anonymous := &fakeAuthenticator{name: policy.AuthenticatorAnonymous, identity: &Identity{}}
+
+	a := newChainAPI(
+		policy.Policy{Name: "api", Authenticators: []string{policy.AuthenticatorJWT, policy.AuthenticatorAnonymous}},
+		jwt, anonymous,
+	)
+
+	w := httptest.NewRecorder()
+	a.check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check", nil))
+
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal(`Bearer error="invalid_token"`, w.Header().Get(wwwAuthenticateHeader))
+	assert.Equal(0, anonymous.calls)
+}
+
+func TestChainAllRequiresEveryAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+
+	cert := &fakeAuthenticator{name: policy.AuthenticatorClientCertificate, identity: &Identity{Subject: "spiffe://cluster.local/ns/a/sa/b"}}
+	jwt := &fakeAuthenticator{name: policy.AuthenticatorJWT, err: ErrNoCredentials}
+
+	p := policy.Policy{
+		Name:           "api",
+		Authenticators: []string{policy.AuthenticatorClientCertificate, policy.AuthenticatorJWT},
+		Mode:           policy.ChainAll,
+	}
+
+	w := httptest.NewRecorder()
+	newChainAPI(p, cert, jwt).check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check", nil))
+
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	jwt.err = nil
+	jwt.identity = &Identity{Subject: "alice", ClientID: "app", Token: "token"}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	identity, err := newChainAPI(p, cert, jwt).authenticate(r, &p)
+
+	assert.Nil(err)
+	assert.Equal("spiffe://cluster.local/ns/a/sa/b", identity.Subject)
+	assert.Equal("app", identity.ClientID)
+}
+
+func TestChainAllFailsOnMissingAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+
+	cert := &fakeAuthenticator{name: policy.AuthenticatorClientCertificate, identity: &Identity{Subject: "spiffe://cluster.local/ns/a/sa/b"}}
+
+	p := policy.Policy{
+		Name:           "api",
+		Authenticators: []string{policy.AuthenticatorClientCertificate, policy.AuthenticatorJWT},
+		Mode:           policy.ChainAll,
+	}
+
+	w := httptest.NewRecorder()
+	newChainAPI(p, cert).check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check", nil))
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(resultDenied, w.Header().Get(resultHeader))
+}
+
+func TestIdentityHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	anonymous := &fakeAuthenticator{
+		name:     policy.AuthenticatorAnonymous,
+		identity: &Identity{Subject: "alice", Claims: map[string]interface{}{"traits": map[string]interface{}{"email": "alice@example.com"}}},
+	}
+
+	a := NewAPI(
+		policy.NewStore(&policy.Set{Policies: []policy.Policy{{Name: "api", Authenticators: []string{policy.AuthenticatorAnonymous}}}}, "test"),
+		[]AuthenticatorInterface{anonymous},
+		&Config{
+			Limiter:         ratelimit.NewLocal(),
+			MaxBodyBytes:    1024,
+			IdentityHeaders: map[string]string{"x-user-id": "subject", "x-user-email": "claims.traits.email", "x-client-id": "client_id"},
+		},
+		logging.NewNoopLogger(),
+	)
+
+	w := httptest.NewRecorder()
+	a.check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check", nil))
+
+	assert.Equal("alice", w.Header().Get("x-user-id"))
+	assert.Equal("alice@example.com", w.Header().Get("x-user-email"))
+	assert.Empty(w.Header().Values("x-client-id"))
+	assert.Empty(w.Header().Get(kubeflowHeader))
+}
+
+func TestClientCertificateTrustedProxy(t *testing.T) {
+	tests := []struct {
+		name   string
+		by     string
+		header string
+		status int
+	}{
+		{"trusted proxy", "spiffe://cluster.local/ns/istio/sa/gw", "By=spiffe://cluster.local/ns/istio/sa/gw;URI=spiffe://cluster.local/ns/orders/sa/api", http.StatusOK},
+		{"header ignored when no proxy is configured", "", "By=spiffe://cluster.local/ns/istio/sa/gw;URI=spiffe://cluster.local/ns/orders/sa/api", http.StatusForbidden},
+		{"element sent by the client", "spiffe://cluster.local/ns/istio/sa/gw", "By=spiffe://cluster.local/ns/istio/sa/gw;URI=spiffe://cluster.local/ns/orders/sa/api,By=spiffe://cluster.local/ns/istio/sa/gw;URI=spiffe://cluster.local/ns/other/sa/x", http.StatusForbidden},
+		{"element added by another proxy", "spiffe://cluster.local/ns/istio/sa/gw", "By=spiffe://evil;URI=spiffe://cluster.local/ns/orders/sa/api", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := NewAPI(
+				policy.NewStore(&policy.Set{Policies: []policy.Policy{{Name: "orders", Authenticators: []string{policy.AuthenticatorClientCertificate}, Workloads: []string{"spiffe://cluster.local/ns/orders/sa/*"}}}}, "test"),
+				[]AuthenticatorInterface{NewClientCertificateAuthenticator(logging.NewNoopLogger())},
+				&Config{XFCCBy: test.by},
+				logging.NewNoopLogger(),
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+			r.Header.Set("X-Forwarded-Client-Cert", test.header)
+
+			w := httptest.NewRecorder()
+			a.check(w, r)
+
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+}
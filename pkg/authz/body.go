@@ -0,0 +1,86 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// partialBodyHeader is set by envoy when the body was truncated to max_request_bytes
+const partialBodyHeader = "x-envoy-auth-partial-body"
+
+// readBody reads the request body forwarded by envoy when the policy needs it, up to the
+// configured limit; larger bodies are rejected with a 413 and false returned
+func (a *API) readBody(w http.ResponseWriter, r *http.Request, p *policy.Policy) ([]byte, bool) {
+	if p == nil || !p.ReadBody {
+		return nil, true
+	}
+
+	// a truncated body can't be checked against the policy conditions
+	if strings.EqualFold(r.Header.Get(partialBodyHeader), "true") && len(p.Conditions) > 0 {
+		a.bodyTooLarge(w, r, p, "truncated by envoy")
+		return nil, false
+	}
+
+	if r.ContentLength > a.maxBodyBytes {
+		a.bodyTooLarge(w, r, p, fmt.Sprintf("%d bytes", r.ContentLength))
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, a.maxBodyBytes))
+
+	maxBytesErr := new(http.MaxBytesError)
+
+	if errors.As(err, &maxBytesErr) {
+		a.bodyTooLarge(w, r, p, fmt.Sprintf("over %d bytes", maxBytesErr.Limit))
+		return nil, false
+	}
+
+	if err != nil {
+		a.log(r).Infof("[HTTP] read body failed: %v", err)
+		a.denied(w, r, p, http.StatusBadRequest, reasonBadRequest, "")
+		return nil, false
+	}
+
+	return body, true
+}
+
+func (a *API) bodyTooLarge(w http.ResponseWriter, r *http.Request, p *policy.Policy, size string) {
+	a.log(r).Infof("[HTTP][denied]: request body %s on policy %s, limit is %d bytes", size, p.Name, a.maxBodyBytes)
+
+	a.denied(
+		w, r, p, http.StatusRequestEntityTooLarge, reasonBodyTooLarge,
+		fmt.Sprintf("request body exceeds the limit of %d bytes", a.maxBodyBytes),
+	)
+}
+
+// matchesConditions evaluates the body conditions of the policy, on failure a 403 is written
+// and false returned
+func (a *API) matchesConditions(w http.ResponseWriter, r *http.Request, p *policy.Policy, body []byte, l string) bool {
+	if p == nil {
+		return true
+	}
+
+	matches, err := p.MatchesBody(body)
+
+	if err != nil {
+		a.log(r).Infof("[HTTP][denied]: policy %s: %v %s", p.Name, err, l)
+	} else if !matches {
+		a.log(r).Infof("[HTTP][denied]: body conditions of policy %s not met %s", p.Name, l)
+	}
+
+	if matches {
+		return true
+	}
+
+	a.denied(w, r, p, http.StatusForbidden, reasonConditionsNotMet, "")
+
+	return false
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+func newBodyAPI(readBody bool) *API {
+	p := policy.Policy{
+		Name:           "orders",
+		Authenticators: []string{policy.AuthenticatorAnonymous},
+		ReadBody:       readBody,
+		Conditions:     []policy.Condition{{JSONField: "action", Values: []string{"read"}}},
+	}
+
+	if !readBody {
+		p.Conditions = nil
+	}
+
+	return NewAPI(
+		policy.NewStore(&policy.Set{Policies: []policy.Policy{p}}, "test"), []AuthenticatorInterface{NewAnonymousAuthenticator()},
+		&Config{Limiter: ratelimit.NewLocal(), MaxBodyBytes: 32}, logging.NewNoopLogger(),
+	)
+}
+
+func TestCheckBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		readBody bool
+		body     string
+		partial  bool
+		expected int
+	}{
+		{"condition holds", true, `{"action": "read"}`, false, http.StatusOK},
+		{"condition fails", true, `{"action": "write"}`, false, http.StatusForbidden},
+		{"body too large", true, `{"action": "read", "padding": "xxxxxxxxxxxxxxxxxxxxxxx"}`, false, http.StatusRequestEntityTooLarge},
+		{"partial body", true, `{"action": "read"}`, true, http.StatusRequestEntityTooLarge},
+		{"body ignored", false, strings.Repeat("x", 1024), false, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v0/check", strings.NewReader(test.body))
+
+			if test.partial {
+				r.Header.Set(partialBodyHeader, "true")
+			}
+
+			w := httptest.NewRecorder()
+			newBodyAPI(test.readBody).check(w, r)
+
+			assert.Equal(t, test.expected, w.Code)
+		})
+	}
+}
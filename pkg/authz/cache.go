@@ -0,0 +1,223 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	kClient "github.com/ory/kratos-client-go"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const (
+	sessionTokenKey    = "session:token:"
+	sessionRevokedKey  = "session:revoked:"
+	identityRevokedKey = "identity:revoked:"
+	tokenRevokedKey    = "token:revoked:"
+
+	// revocationSkew widens the revocation window to cope with clock drift between
+	// replicas sharing the same cache
+	revocationSkew = 5 * time.Second
+)
+
+type cachedSession struct {
+	Session *kClient.Session `json:"session"`
+	// FetchedAt is taken before calling kratos so that a logout racing with the lookup
+	// always wins over the cached copy
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// SessionCache keeps kratos sessions keyed by session token, revocation by session or identity
+// is done with markers so that it works the same way on a shared cache across replicas
+type SessionCache struct {
+	cache      cache.CacheInterface
+	ttl        time.Duration
+	cookieName string
+	// namespace separates the sessions of the kratos backends sharing the cache
+	namespace string
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+func (c *SessionCache) Enabled() bool {
+	return c.ttl > 0
+}
+
+// Namespace returns a copy of the cache keeping the sessions of another kratos backend apart,
+// revocation markers are keyed on kratos IDs and stay shared so the eviction webhook reaches them
+func (c *SessionCache) Namespace(name string) *SessionCache {
+	n := *c
+	n.namespace = name + ":"
+
+	return &n
+}
+
+// Token returns the value of the kratos session cookie, used as cache key
+func (c *SessionCache) Token(cookies []*http.Cookie) string {
+	for _, cookie := range cookies {
+		if cookie.Name == c.cookieName {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
+
+func (c *SessionCache) Get(ctx context.Context, token string) *kClient.Session {
+	if !c.Enabled() || token == "" {
+		return nil
+	}
+
+	ctx, span := c.tracer.Start(ctx, "authz.SessionCache.Get")
+	defer span.End()
+
+	raw, err := c.cache.Get(ctx, c.tokenKey(token))
+
+	if err != nil {
+		if !errors.Is(err, cache.ErrCacheMiss) {
+			logging.FromContext(ctx, c.logger).Errorf("error fetching session from cache: %v", err)
+		}
+
+		return nil
+	}
+
+	entry := new(cachedSession)
+
+	if err := json.Unmarshal(raw, entry); err != nil || entry.Session == nil {
+		logging.FromContext(ctx, c.logger).Errorf("error decoding cached session: %v", err)
+		return nil
+	}
+
+	if c.revoked(ctx, sessionRevokedKey+entry.Session.Id, entry.FetchedAt) ||
+		c.revoked(ctx, identityRevokedKey+entry.Session.GetIdentity().Id, entry.FetchedAt) ||
+		c.revoked(ctx, tokenRevokedKey+hashToken(token), entry.FetchedAt) {
+		return nil
+	}
+
+	if expiresAt := entry.Session.ExpiresAt; expiresAt != nil && time.Now().After(*expiresAt) {
+		return nil
+	}
+
+	return entry.Session
+}
+
+func (c *SessionCache) Store(ctx context.Context, token string, session *kClient.Session, fetchedAt time.Time) {
+	if !c.Enabled() || token == "" || session == nil {
+		return
+	}
+
+	ctx, span := c.tracer.Start(ctx, "authz.SessionCache.Store")
+	defer span.End()
+
+	ttl := c.ttl
+
+	if session.ExpiresAt != nil {
+		if remaining := time.Until(*session.ExpiresAt); remaining < ttl {
+			ttl = remaining
+		}
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	raw, err := json.Marshal(cachedSession{Session: session, FetchedAt: fetchedAt})
+
+	if err != nil {
+		logging.FromContext(ctx, c.logger).Errorf("error encoding session for cache: %v", err)
+		return
+	}
+
+	if err := c.cache.Set(ctx, c.tokenKey(token), raw, ttl); err != nil {
+		logging.FromContext(ctx, c.logger).Errorf("error storing session in cache: %v", err)
+	}
+}
+
+// EvictSession invalidates every cached copy of the session with the given ID
+func (c *SessionCache) EvictSession(ctx context.Context, id string) error {
+	return c.markRevoked(ctx, sessionRevokedKey+id)
+}
+
+// EvictIdentity invalidates all the cached sessions belonging to the identity
+func (c *SessionCache) EvictIdentity(ctx context.Context, id string) error {
+	return c.markRevoked(ctx, identityRevokedKey+id)
+}
+
+// EvictToken drops the cached session associated with the session token or cookie value, the
+// entries of the other namespaces are shadowed by a marker keyed on the token hash
+func (c *SessionCache) EvictToken(ctx context.Context, token string) error {
+	ctx, span := c.tracer.Start(ctx, "authz.SessionCache.EvictToken")
+	defer span.End()
+
+	if err := c.cache.Delete(ctx, c.tokenKey(token)); err != nil {
+		return err
+	}
+
+	return c.markRevoked(ctx, tokenRevokedKey+hashToken(token))
+}
+
+func (c *SessionCache) markRevoked(ctx context.Context, key string) error {
+	ctx, span := c.tracer.Start(ctx, "authz.SessionCache.markRevoked")
+	defer span.End()
+
+	// marker only needs to outlive the entries it shadows
+	ttl := c.ttl + revocationSkew
+
+	return c.cache.Set(ctx, key, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), ttl)
+}
+
+func (c *SessionCache) revoked(ctx context.Context, key string, fetchedAt time.Time) bool {
+	raw, err := c.cache.Get(ctx, key)
+
+	if errors.Is(err, cache.ErrCacheMiss) {
+		return false
+	}
+
+	if err != nil {
+		logging.FromContext(ctx, c.logger).Errorf("error fetching revocation marker, ignoring cached session: %v", err)
+		return true
+	}
+
+	revokedAt, err := strconv.ParseInt(string(raw), 10, 64)
+
+	if err != nil {
+		return true
+	}
+
+	return fetchedAt.Before(time.Unix(0, revokedAt).Add(revocationSkew))
+}
+
+func (c *SessionCache) tokenKey(token string) string {
+	return sessionTokenKey + c.namespace + hashToken(token)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func NewSessionCache(c cache.CacheInterface, ttl time.Duration, cookieName string, tracer tracing.TracingInterface, logger logging.LoggerInterface) *SessionCache {
+	s := new(SessionCache)
+
+	s.cache = c
+	s.ttl = ttl
+	s.cookieName = cookieName
+
+	s.tracer = tracer
+	s.logger = logger
+
+	return s
+}
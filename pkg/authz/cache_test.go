@@ -0,0 +1,137 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	kClient "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+func newTestSessionCache(c cache.CacheInterface) *SessionCache {
+	return NewSessionCache(c, time.Minute, "ory_kratos_session", tracing.NewNoopTracer(), logging.NewNoopLogger())
+}
+
+func newTestSession(id, identityID string, expiresAt time.Time) *kClient.Session {
+	session := kClient.NewSession(id)
+	session.Identity = kClient.NewIdentity(identityID, "default", "", map[string]interface{}{"email": "alice@example.com"})
+	session.ExpiresAt = &expiresAt
+
+	return session
+}
+
+func TestSessionCacheStoreGet(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestSessionCache(cache.NewMemory())
+	session := newTestSession("s1", "i1", time.Now().Add(time.Hour))
+
+	assert.Nil(c.Get(context.TODO(), "token"))
+
+	c.Store(context.TODO(), "token", session, time.Now())
+
+	assert.Equal("s1", c.Get(context.TODO(), "token").Id)
+	assert.Nil(c.Get(context.TODO(), "other"))
+
+	assert.Nil(c.EvictToken(context.TODO(), "token"))
+	assert.Nil(c.Get(context.TODO(), "token"))
+
+	// expired sessions are never served
+	c.Store(context.TODO(), "expired", newTestSession("s2", "i1", time.Now().Add(-time.Second)), time.Now())
+
+	assert.Nil(c.Get(context.TODO(), "expired"))
+}
+
+func TestSessionCacheDisabled(t *testing.T) {
+	c := NewSessionCache(cache.NewMemory(), 0, "ory_kratos_session", tracing.NewNoopTracer(), logging.NewNoopLogger())
+
+	c.Store(context.TODO(), "token", newTestSession("s1", "i1", time.Now().Add(time.Hour)), time.Now())
+
+	assert.Nil(t, c.Get(context.TODO(), "token"))
+}
+
+func TestSessionCacheEviction(t *testing.T) {
+	tests := []struct {
+		name  string
+		evict func(*SessionCache) error
+	}{
+		{"session", func(c *SessionCache) error { return c.EvictSession(context.TODO(), "s1") }},
+		{"identity", func(c *SessionCache) error { return c.EvictIdentity(context.TODO(), "i1") }},
+		{"token", func(c *SessionCache) error { return c.EvictToken(context.TODO(), "token") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			c := newTestSessionCache(cache.NewMemory())
+
+			c.Store(context.TODO(), "token", newTestSession("s1", "i1", time.Now().Add(time.Hour)), time.Now())
+			c.Store(context.TODO(), "other", newTestSession("s2", "i2", time.Now().Add(time.Hour)), time.Now())
+
+			assert.Nil(test.evict(c))
+
+			assert.Nil(c.Get(context.TODO(), "token"))
+			assert.NotNil(c.Get(context.TODO(), "other"), "other sessions are kept")
+
+			// a lookup started within the skew of the revocation could have raced with it
+			c.Store(context.TODO(), "token", newTestSession("s1", "i1", time.Now().Add(time.Hour)), time.Now().Add(revocationSkew/2))
+
+			assert.Nil(c.Get(context.TODO(), "token"))
+
+			// a session fetched after the revocation is served again, e.g. after a new login
+			c.Store(context.TODO(), "token", newTestSession("s1", "i1", time.Now().Add(time.Hour)), time.Now().Add(2*revocationSkew))
+
+			assert.NotNil(c.Get(context.TODO(), "token"))
+		})
+	}
+}
+
+func TestSessionCacheMalformedMarker(t *testing.T) {
+	shared := cache.NewMemory()
+	c := newTestSessionCache(shared)
+
+	c.Store(context.TODO(), "token", newTestSession("s1", "i1", time.Now().Add(time.Hour)), time.Now())
+
+	_ = shared.Set(context.TODO(), sessionRevokedKey+"s1", []byte("garbage"), time.Minute)
+
+	assert.Nil(t, c.Get(context.TODO(), "token"), "unreadable markers revoke the session")
+}
+
+func TestSessionCacheToken(t *testing.T) {
+	c := newTestSessionCache(cache.NewMemory())
+
+	assert.Equal(t, "value", c.Token([]*http.Cookie{{Name: "other", Value: "x"}, {Name: "ory_kratos_session", Value: "value"}}))
+	assert.Equal(t, "", c.Token(nil))
+}
+
+func TestSessionCacheNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	shared := cache.NewMemory()
+	c := newTestSessionCache(shared)
+	tenant := c.Namespace("acme")
+
+	tenant.Store(context.TODO(), "token", newTestSession("s1", "i1", time.Now().Add(time.Hour)), time.Now())
+
+	assert.NotNil(tenant.Get(context.TODO(), "token"))
+	assert.Nil(c.Get(context.TODO(), "token"), "sessions of a tenant are not served to others")
+	assert.Nil(c.Namespace("globex").Get(context.TODO(), "token"))
+
+	assert.Nil(c.EvictSession(context.TODO(), "s1"))
+	assert.Nil(tenant.Get(context.TODO(), "token"), "revocations reach every tenant")
+
+	tenant.Store(context.TODO(), "other", newTestSession("s2", "i2", time.Now().Add(time.Hour)), time.Now())
+
+	assert.Nil(c.EvictToken(context.TODO(), "other"))
+	assert.Nil(tenant.Get(context.TODO(), "other"), "token evictions reach every tenant")
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ChallengeConfig holds the parameters added to every WWW-Authenticate challenge
+type ChallengeConfig struct {
+	// Realm is the protection space of RFC 6750
+	Realm string
+	// ResourceMetadata is the URL of the RFC 9728 protected resource metadata
+	ResourceMetadata string
+}
+
+// header adds realm and resource_metadata to the challenge built by the authenticator
+func (c *ChallengeConfig) header(challenge string) string {
+	if c == nil {
+		return challenge
+	}
+
+	scheme, params, _ := strings.Cut(challenge, " ")
+	parts := make([]string, 0, 3)
+
+	if c.Realm != "" {
+		parts = append(parts, fmt.Sprintf(`realm="%s"`, c.Realm))
+	}
+
+	if params != "" {
+		parts = append(parts, params)
+	}
+
+	if c.ResourceMetadata != "" {
+		parts = append(parts, fmt.Sprintf(`resource_metadata="%s"`, c.ResourceMetadata))
+	}
+
+	if len(parts) == 0 {
+		return scheme
+	}
+
+	return fmt.Sprintf("%s %s", scheme, strings.Join(parts, ", "))
+}
+
+func NewChallengeConfig(realm, resourceMetadata string) *ChallengeConfig {
+	c := new(ChallengeConfig)
+
+	c.Realm = realm
+	c.ResourceMetadata = resourceMetadata
+
+	return c
+}
+
+// tokenChallenge is the challenge of a rejected access token, in the DPoP scheme (RFC 9449)
+// when the token was presented with it and in the bearer one (RFC 6750) otherwise
+func tokenChallenge(status int, scheme, code, description string, err error) *AuthError {
+	if scheme == dpopScheme {
+		e := dpopChallenge(code, description, err)
+		e.Status = status
+
+		return e
+	}
+
+	return authError(status, fmt.Sprintf(`Bearer error="%s", error_description="%s"`, code, description), err)
+}
+
+// invalidToken answers inactive, expired or otherwise unusable tokens with a 401
+func invalidToken(scheme, description string, err error) *AuthError {
+	return tokenChallenge(http.StatusUnauthorized, scheme, "invalid_token", description, err)
+}
+
+// insufficientScope answers tokens missing scopes required by the policy with a 403
+func insufficientScope(scheme string, scopes []string) *AuthError {
+	e := tokenChallenge(
+		http.StatusForbidden, scheme, "insufficient_scope", "the access token lacks required scopes",
+		fmt.Errorf("scopes %v required", scopes),
+	)
+	e.Challenge = fmt.Sprintf(`%s, scope="%s"`, e.Challenge, strings.Join(scopes, " "))
+
+	return e
+}
+
+// unavailable answers failures to reach kratos, hydra or the key set of the issuer with a 503,
+// other failures are internal errors
+func unavailable(err error) *AuthError {
+	if errors.Is(err, ErrUnavailable) {
+		return authError(http.StatusServiceUnavailable, "", err)
+	}
+
+	return authError(http.StatusInternalServerError, "", err)
+}
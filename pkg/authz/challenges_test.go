@@ -0,0 +1,108 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hClient "github.com/ory/hydra-client-go/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+type fakeIntrospection struct {
+	ServiceInterface
+
+	token *hClient.IntrospectedOAuth2Token
+	err   error
+}
+
+func (f *fakeIntrospection) CheckToken(context.Context, string) (*hClient.IntrospectedOAuth2Token, error) {
+	return f.token, f.err
+}
+
+func TestTokenChallenges(t *testing.T) {
+	active := hClient.NewIntrospectedOAuth2Token(true)
+	active.SetSub("alice")
+	active.SetScope("orders:read")
+
+	tests := []struct {
+		name      string
+		service   *fakeIntrospection
+		status    int
+		challenge string
+	}{
+		{
+			name:      "inactive token",
+			service:   &fakeIntrospection{token: hClient.NewIntrospectedOAuth2Token(false)},
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="orders", error="invalid_token", error_description="the access token is inactive or expired", resource_metadata="https://orders.example.com/.well-known/oauth-protected-resource"`,
+		},
+		{
+			name:      "missing scope",
+			service:   &fakeIntrospection{token: active},
+			status:    http.StatusForbidden,
+			challenge: `Bearer realm="orders", error="insufficient_scope", error_description="the access token lacks required scopes", scope="orders:read orders:write", resource_metadata="https://orders.example.com/.well-known/oauth-protected-resource"`,
+		},
+		{
+			name:    "hydra unavailable",
+			service: &fakeIntrospection{err: upstreamError(nil, fmt.Errorf("connection refused"))},
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "hydra rejecting the request",
+			service: &fakeIntrospection{err: upstreamError(&http.Response{StatusCode: http.StatusUnauthorized}, fmt.Errorf("unauthorized"))},
+			status:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			p := policy.Policy{Name: "orders", Authenticators: []string{policy.AuthenticatorIntrospection}, Scopes: []string{"orders:read", "orders:write"}}
+
+			a := NewAPI(
+				policy.NewStore(&policy.Set{Policies: []policy.Policy{p}}, "test"),
+				[]AuthenticatorInterface{NewIntrospectionAuthenticator(test.service, nil, logging.NewNoopLogger())},
+				&Config{
+					Limiter:      ratelimit.NewLocal(),
+					Challenges:   NewChallengeConfig("orders", "https://orders.example.com/.well-known/oauth-protected-resource"),
+					MaxBodyBytes: 1024,
+				},
+				logging.NewNoopLogger(),
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+			r.Header.Set("Authorization", "Bearer opaque")
+
+			w := httptest.NewRecorder()
+			a.check(w, r)
+
+			assert.Equal(test.status, w.Code)
+			assert.Equal(test.challenge, w.Header().Get(wwwAuthenticateHeader))
+		})
+	}
+}
+
+func TestCertificateBindingChallengeFollowsScheme(t *testing.T) {
+	assert := assert.New(t)
+
+	cnf := map[string]interface{}{x5tS256: "thumbprint"}
+
+	err := verifyCertificateBinding(bearerScheme, nil, cnf)
+	assert.Equal(`Bearer error="invalid_token", error_description="token is not bound to the client certificate"`, err.(*AuthError).Challenge)
+
+	err = verifyCertificateBinding(dpopScheme, nil, cnf)
+	assert.Equal(http.StatusUnauthorized, err.(*AuthError).Status)
+	assert.Contains(err.(*AuthError).Challenge, `DPoP `)
+	assert.Contains(err.(*AuthError).Challenge, `error="invalid_token"`)
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	hClient "github.com/ory/hydra-client-go/v2"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/xfcc"
+)
+
+const x5tS256 = "x5t#S256"
+
+// confirmation returns the cnf claim of the token, looked up in the introspection response
+// first, then in its extra claims and at last in the token itself when it is a JWT; the token
+// has already been validated by introspection so the JWT payload is not verified again
+func confirmation(token *hClient.IntrospectedOAuth2Token, rawToken string) map[string]interface{} {
+	if cnf, ok := token.AdditionalProperties["cnf"].(map[string]interface{}); ok {
+		return cnf
+	}
+
+	if cnf, ok := token.Ext["cnf"].(map[string]interface{}); ok {
+		return cnf
+	}
+
+	parts := strings.Split(rawToken, ".")
+
+	if len(parts) != 3 {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil
+	}
+
+	claims := struct {
+		Confirmation map[string]interface{} `json:"cnf"`
+	}{}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+
+	return claims.Confirmation
+}
+
+// verifyCertificateBinding makes sure certificate bound tokens (RFC 8705) are presented over
+// a connection using the same client certificate
+func verifyCertificateBinding(scheme string, cert *xfcc.Certificate, cnf map[string]interface{}) error {
+	bound, _ := cnf[x5tS256].(string)
+
+	if bound == "" || (cert != nil && cert.Thumbprint == bound) {
+		return nil
+	}
+
+	return invalidToken(
+		scheme,
+		"token is not bound to the client certificate",
+		fmt.Errorf("certificate binding mismatch, client certificate present: %t", cert != nil),
+	)
+}
+
+// allowedWorkload enforces the workload identities of the policy on the client certificate
+func allowedWorkload(p *policy.Policy, cert *xfcc.Certificate) error {
+	if p == nil || len(p.Workloads) == 0 {
+		return nil
+	}
+
+	if cert != nil && p.AllowsWorkload(cert.URIs, cert.Subject) {
+		return nil
+	}
+
+	return authError(http.StatusForbidden, "", fmt.Errorf("client certificate not allowed by policy %s", p.Name))
+}
+
+// workloadIdentity returns the identity used as subject for certificate authenticated calls
+func workloadIdentity(cert *xfcc.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0]
+	}
+
+	return cert.Subject
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const debugHeader = "x-ext-authz-debug"
+
+// DebugConfig holds the development only behaviours of the check endpoint, both are off unless
+// explicitly configured
+type DebugConfig struct {
+	// DemoMode lets through every request carrying the x-ext-authz: allow header, as in the
+	// envoy ext_authz example
+	DemoMode bool
+	// EchoNetworks lists the networks of the peers allowed to ask for the debug echo
+	EchoNetworks []*net.IPNet
+}
+
+// echo tells if the request asked for the debug echo and the peer calling the authorizer is in a
+// trusted network, forwarded client addresses are never used as they can be spoofed
+func (c *DebugConfig) echo(r *http.Request) bool {
+	if c == nil || len(c.EchoNetworks) == 0 || r.Header.Get(debugHeader) == "" {
+		return false
+	}
+
+	ip := net.ParseIP(remoteIP(r))
+
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range c.EchoNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *DebugConfig) demo() bool {
+	return c != nil && c.DemoMode
+}
+
+// NewDebugConfig parses the trusted echo networks, plain IPs are accepted as single host networks
+func NewDebugConfig(demoMode bool, echoNetworks []string) (*DebugConfig, error) {
+	c := new(DebugConfig)
+
+	c.DemoMode = demoMode
+	c.EchoNetworks = make([]*net.IPNet, 0, len(echoNetworks))
+
+	for _, n := range echoNetworks {
+		n = strings.TrimSpace(n)
+
+		if !strings.Contains(n, "/") {
+			if ip := net.ParseIP(n); ip != nil && ip.To4() != nil {
+				n = n + "/32"
+			} else {
+				n = n + "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(n)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid debug echo network %q: %w", n, err)
+		}
+
+		c.EchoNetworks = append(c.EchoNetworks, network)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+func newDebugAPI(t *testing.T, demoMode bool, networks ...string) *API {
+	debug, err := NewDebugConfig(demoMode, networks)
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	chain := policy.Policy{Name: "api", Authenticators: []string{policy.AuthenticatorJWT}}
+	jwt := &fakeAuthenticator{name: policy.AuthenticatorJWT, err: ErrNoCredentials}
+
+	return NewAPI(policy.NewStore(&policy.Set{Policies: []policy.Policy{chain}}, "test"), []AuthenticatorInterface{jwt}, &Config{Limiter: ratelimit.NewLocal(), Debug: debug, MaxBodyBytes: 1024}, logging.NewNoopLogger())
+}
+
+func TestTestHeaderIgnoredByDefault(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	r.Header.Set(checkHeader, allowedValue)
+
+	w := httptest.NewRecorder()
+	newDebugAPI(t, false).check(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NotContains(t, w.Body.String(), checkHeader)
+}
+
+func TestTestHeaderAllowedInDemoMode(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	r.Header.Set(checkHeader, allowedValue)
+
+	w := httptest.NewRecorder()
+	newDebugAPI(t, true).check(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDebugEchoOnlyForTrustedNetworks(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newDebugAPI(t, false, "10.0.0.0/8", "192.168.1.10")
+
+	for ip, expected := range map[string]bool{"10.1.2.3": true, "192.168.1.10": true, "192.168.1.11": false} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set(debugHeader, "echo")
+		r.Header.Set("x-ext-authz-additional-header-override", "reflected")
+
+		w := httptest.NewRecorder()
+		a.check(w, r)
+
+		assert.Equal(expected, w.Header().Get(receivedHeader) != "", ip)
+		assert.Empty(w.Header().Get("x-ext-authz-additional-header-override"), "client headers are never reflected")
+	}
+
+	// forwarded addresses are set by the client
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	r.RemoteAddr = "192.168.1.11:1234"
+	r.Header.Set("X-Envoy-External-Address", "10.1.2.3")
+	r.Header.Set("X-Forwarded-For", "10.1.2.3")
+	r.Header.Set(debugHeader, "echo")
+
+	w := httptest.NewRecorder()
+	a.check(w, r)
+
+	assert.Empty(w.Header().Get(receivedHeader))
+
+	r = httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+
+	w = httptest.NewRecorder()
+	a.check(w, r)
+
+	assert.Empty(w.Header().Get(receivedHeader))
+}
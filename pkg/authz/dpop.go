@@ -0,0 +1,95 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/dpop"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+const (
+	bearerScheme = "bearer"
+	dpopScheme   = "dpop"
+	dpopHeader   = "DPoP"
+
+	wwwAuthenticateHeader = "WWW-Authenticate"
+)
+
+// authorizationCredentials splits the Authorization header in lowercase scheme and token,
+// a token without scheme is treated as a bearer one
+func authorizationCredentials(r *http.Request) (string, string) {
+	authorization := strings.TrimSpace(r.Header.Get("Authorization"))
+
+	if authorization == "" {
+		return "", ""
+	}
+
+	scheme, token, found := strings.Cut(authorization, " ")
+
+	if !found {
+		return bearerScheme, authorization
+	}
+
+	return strings.ToLower(scheme), strings.TrimSpace(token)
+}
+
+// verifyDPoPProof validates the DPoP proof when the DPoP scheme is used and returns the
+// proof key thumbprint
+func verifyDPoPProof(r *http.Request, p *policy.Policy, validator DPoPValidatorInterface, scheme, accessToken string) (string, error) {
+	switch {
+	case scheme == dpopScheme:
+	case p != nil && p.RequireDPoP:
+		return "", dpopChallenge("invalid_token", "DPoP bound token required", fmt.Errorf("bearer token on DPoP only policy %s", p.Name))
+	default:
+		return "", nil
+	}
+
+	proofs := r.Header.Values(dpopHeader)
+
+	if validator == nil || len(proofs) != 1 {
+		return "", dpopChallenge("invalid_dpop_proof", "exactly one DPoP proof is required", fmt.Errorf("%d DPoP proofs", len(proofs)))
+	}
+
+	// htu is compared without query, as mandated by RFC 9449
+	requestURL := strings.SplitN(originalURL(r), "?", 2)[0]
+
+	jkt, err := validator.Validate(r.Context(), proofs[0], originalMethod(r), requestURL, accessToken)
+
+	if err != nil {
+		return "", dpopChallenge("invalid_dpop_proof", "DPoP proof validation failed", err)
+	}
+
+	return jkt, nil
+}
+
+// verifyTokenBinding makes sure DPoP bound tokens are only used with a proof from the bound key,
+// and that they are never accepted with the plain bearer scheme
+func verifyTokenBinding(scheme, proofKey string, cnf map[string]interface{}) error {
+	bound, _ := cnf["jkt"].(string)
+
+	if scheme != dpopScheme && bound == "" {
+		return nil
+	}
+
+	if scheme == dpopScheme && bound != "" && bound == proofKey {
+		return nil
+	}
+
+	return dpopChallenge(
+		"invalid_token", "token is not bound to the DPoP proof key",
+		fmt.Errorf("token binding mismatch, scheme: %s bound: %t", scheme, bound != ""),
+	)
+}
+
+func dpopChallenge(errorCode, description string, err error) *AuthError {
+	return authError(
+		http.StatusUnauthorized,
+		fmt.Sprintf(`DPoP algs="%s", error="%s", error_description="%s"`, dpop.AlgorithmsChallenge(), errorCode, description),
+		err,
+	)
+}
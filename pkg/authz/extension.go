@@ -0,0 +1,84 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"time"
+
+	kClient "github.com/ory/kratos-client-go"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const sessionExtendedKey = "session:extended:"
+
+// SessionExtender slides kratos sessions about to expire through the admin API, each session
+// is extended at most once per window across the replicas sharing the cache
+type SessionExtender struct {
+	kratos KratosAdminClientInterface
+	window time.Duration
+	cache  cache.CacheInterface
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// due tells if the session expires within the window
+func (e *SessionExtender) due(session *kClient.Session) bool {
+	if e == nil || session == nil || session.ExpiresAt == nil || session.Id == "" {
+		return false
+	}
+
+	return time.Until(*session.ExpiresAt) < e.window
+}
+
+// Extend extends the session when due, nil is returned when it isn't or when another request
+// already took care of it in the current window
+func (e *SessionExtender) Extend(ctx context.Context, session *kClient.Session) (*kClient.Session, error) {
+	if !e.due(session) {
+		return nil, nil
+	}
+
+	ctx, span := e.tracer.Start(ctx, "authz.SessionExtender.Extend")
+	defer span.End()
+
+	first, err := e.cache.SetIfAbsent(ctx, sessionExtendedKey+session.Id, []byte(session.Id), e.window)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !first {
+		return nil, nil
+	}
+
+	extended, resp, err := e.kratos.IdentityAPI().ExtendSession(ctx, session.Id).Execute()
+
+	if err != nil {
+		// let the next request in the window try again
+		_ = e.cache.Delete(ctx, sessionExtendedKey+session.Id)
+
+		return nil, upstreamError(resp, err)
+	}
+
+	logging.FromContext(ctx, e.logger).Debugf("session %s extended until %v", extended.Id, extended.GetExpiresAt())
+
+	return extended, nil
+}
+
+func NewSessionExtender(kratos KratosAdminClientInterface, window time.Duration, c cache.CacheInterface, tracer tracing.TracingInterface, logger logging.LoggerInterface) *SessionExtender {
+	e := new(SessionExtender)
+
+	e.kratos = kratos
+	e.window = window
+	e.cache = c
+
+	e.tracer = tracer
+	e.logger = logger
+
+	return e
+}
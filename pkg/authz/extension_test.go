@@ -0,0 +1,76 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kClient "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	ik "github.com/shipperizer/iam-ext-authz/internal/kratos"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+func TestSessionExtender(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		assert.Equal(http.MethodPatch, r.Method)
+		assert.Equal("/admin/sessions/expiring/extend", r.URL.Path)
+
+		session := kClient.NewSessionWithDefaults()
+		session.SetId("expiring")
+		session.SetExpiresAt(time.Now().Add(time.Hour))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(session)
+	}))
+	defer server.Close()
+
+	e := NewSessionExtender(ik.NewClient(server.URL, false), 10*time.Minute, cache.NewMemory(), tracing.NewNoopTracer(), logging.NewNoopLogger())
+
+	fresh := kClient.NewSessionWithDefaults()
+	fresh.SetId("fresh")
+	fresh.SetExpiresAt(time.Now().Add(time.Hour))
+
+	extended, err := e.Extend(context.Background(), fresh)
+
+	assert.Nil(err)
+	assert.Nil(extended)
+
+	expiring := kClient.NewSessionWithDefaults()
+	expiring.SetId("expiring")
+	expiring.SetExpiresAt(time.Now().Add(5 * time.Minute))
+
+	extended, err = e.Extend(context.Background(), expiring)
+
+	assert.Nil(err)
+	assert.True(extended.GetExpiresAt().After(time.Now().Add(50 * time.Minute)))
+
+	// at most once per window
+	extended, err = e.Extend(context.Background(), expiring)
+
+	assert.Nil(err)
+	assert.Nil(extended)
+	assert.Equal(1, calls)
+
+	var disabled *SessionExtender
+
+	extended, err = disabled.Extend(context.Background(), expiring)
+
+	assert.Nil(err)
+	assert.Nil(extended)
+}
@@ -4,16 +4,15 @@
 package authz
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
 )
 
 const (
@@ -21,7 +20,6 @@ const (
 	allowedValue   = "allow"
 	resultHeader   = "x-ext-authz-check-result"
 	receivedHeader = "x-ext-authz-check-received"
-	overrideHeader = "x-ext-authz-additional-header-override"
 	resultAllowed  = "allowed"
 	resultDenied   = "denied"
 	kubeflowHeader = "kubeflow-userid"
@@ -34,119 +32,285 @@ var (
 type API struct {
 	logger logging.LoggerInterface
 
-	service ServiceInterface
+	policies       PoliciesInterface
+	limiter        ratelimit.LimiterInterface
+	authenticators map[string]AuthenticatorInterface
+	exchanger      TokenExchangerInterface
+	rego           RegoEvaluatorInterface
+	debug          *DebugConfig
+	challenges     *ChallengeConfig
+	maxBodyBytes   int64
+	trustedHops    int
+	xfccBy         string
+	// identityHeaders maps upstream headers to identity attributes
+	identityHeaders map[string]string
 }
 
 func (a *API) RegisterEndpoints(mux *chi.Mux) {
-	mux.Get("/api/v0/check", a.check)
+	// envoy sends the check with the method of the original request and appends its path
+	mux.HandleFunc(checkPath, a.check)
+	mux.HandleFunc(checkPath+"/*", a.check)
+
+	for path, adapter := range proxyAdapters {
+		mux.HandleFunc(path, a.adapt(adapter))
+	}
+}
+
+// log returns the logger of the request, carrying request id, trace id and, once authenticated,
+// subject
+func (a *API) log(r *http.Request) logging.LoggerInterface {
+	return logging.FromContext(r.Context(), a.logger)
 }
 
 func (a *API) check(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		a.logger.Infof("[HTTP] read body failed: %v", err)
+	p := a.policies.Policies().Find(OriginalHost(r), originalPath(r), originalMethod(r))
+
+	r = withClientCertificate(r, a.xfccBy, a.logger)
+
+	body, ok := a.readBody(w, r, p)
+
+	if !ok {
+		return
 	}
 
-	l := fmt.Sprintf("%s %s%s, headers: %v, body: [%s]\n", r.Method, r.Host, r.URL, r.Header, body)
+	// bodies are never logged, they can be large and carry anything
+	l := logLine(r, p, len(body))
+
+	// the echo reflects what the authorizer received, only trusted peers can ask for it
+	if a.debug.echo(r) {
+		w.Header().Set(receivedHeader, l)
+	}
 
-	IDToken := ""
-	authorization := r.Header.Get("Authorization")
+	identity, err := a.authenticate(r, p)
 
-	if authorization != "" {
-		IDToken = strings.TrimSpace(strings.Replace(authorization, "Bearer", "", 1))
+	if identity != nil {
+		r = r.WithContext(logging.WithFields(r.Context(), a.logger, "subject", identity.Subject))
 	}
 
-	if IDToken != "" {
-		active, username, err := a.service.CheckToken(r.Context(), IDToken)
+	if err != nil {
+		a.deny(w, r, p, err, l)
+		return
+	}
 
-		if err != nil {
-			a.logger.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	if identity != nil {
+		a.allow(w, r, p, identity, body, l)
+		return
+	}
 
-		if !active {
-			a.logger.Infof("Token not active: %s", IDToken)
-			w.WriteHeader(http.StatusForbidden)
-			return
-		}
+	if c := a.challenger(p); c != nil {
+		a.challenge(c, w, r)
+		return
+	}
 
-		a.logger.Infof("[HTTP][allowed]: %s", l)
-		w.Header().Set(kubeflowHeader, username)
+	// demo mode of the envoy example, a full bypass that is never enabled by default
+	if a.debug.demo() && r.Header.Get(checkHeader) == allowedValue {
+		a.log(r).Infof("[HTTP][allowed][demo]: %s", l)
+		w.Header().Set(resultHeader, resultAllowed)
 		w.WriteHeader(http.StatusOK)
-
 		return
 	}
 
-	session, _, err := a.service.CheckSession(r.Context(), r.Cookies())
+	a.log(r).Infof("[HTTP][denied]: %s", l)
 
-	if err != nil {
-		a.logger.Error(err)
+	detail := ""
 
-		q := r.URL.Query()
-		loginChallenge := q.Get("login_challenge")
+	if a.debug.demo() {
+		detail = denyBody
+	}
 
-		refresh, err := strconv.ParseBool(q.Get("refresh"))
+	a.denied(w, r, p, http.StatusForbidden, reasonAccessDenied, detail)
+}
 
-		refresh = refresh || !(err == nil)
+// allow applies the checks common to every identity, workload, scopes, body conditions, rego decision,
+// rate limits and token exchange,
+// before letting the request through
+func (a *API) allow(w http.ResponseWriter, r *http.Request, p *policy.Policy, identity *Identity, body []byte, l string) {
+	if err := allowedWorkload(p, clientCertificate(r)); err != nil {
+		a.deny(w, r, p, err, l)
+		return
+	}
 
-		returnTo := fmt.Sprintf("%s?login_challenge=%s", r.URL.Path, loginChallenge)
+	if err := requireScopes(r, p, identity); err != nil {
+		a.deny(w, r, p, err, l)
+		return
+	}
 
-		flow, cookies, err := a.service.CreateBrowserLoginFlow(context.Background(), q.Get("aal"), returnTo, loginChallenge, refresh, r.Cookies())
-		if err != nil {
-			http.Error(w, "Failed to create login flow", http.StatusInternalServerError)
+	if !a.matchesConditions(w, r, p, body, l) {
+		return
+	}
+
+	if p != nil && p.Rego != nil {
+		d := a.evaluateRego(w, r, p, identity, body, l)
+
+		if d == nil {
 			return
 		}
 
-		resp, err := flow.MarshalJSON()
+		// headers of allowing decisions are sent upstream
+		for name, value := range d.Headers {
+			w.Header().Set(name, value)
+		}
+	}
+
+	if !a.withinRateLimits(w, r, p, identity.Subject, identity.ClientID) {
+		return
+	}
+
+	if p != nil && p.TokenExchange != nil && identity.Token != "" {
+		exchanged, err := a.exchangeToken(r, p, identity.Token)
 
 		if err != nil {
-			a.logger.Errorf("Error when marshalling Json: %v\n", err)
-			http.Error(w, "Failed to marshall json", http.StatusInternalServerError)
+			a.log(r).Errorf("token exchange failed for policy %s: %v", p.Name, err)
+			a.denied(w, r, p, http.StatusInternalServerError, reasonInternalError, "")
 			return
 		}
 
-		for _, c := range cookies {
-			http.SetCookie(w, c)
+		// envoy replaces the upstream header when listed in allowed_upstream_headers
+		w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", exchanged))
+	}
+
+	a.log(r).Infof("[HTTP][allowed][%s]: %s", identity.Authenticator, l)
+
+	headers := a.identityHeaders
+
+	if len(headers) == 0 {
+		headers = map[string]string{kubeflowHeader: "username"}
+	}
+
+	for header, attribute := range headers {
+		if value := identity.Attribute(attribute); value != "" {
+			w.Header().Set(header, value)
 		}
+	}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write(resp)
+	// envoy sends them to the client when listed in allowed_client_headers_on_success
+	for _, c := range identity.Cookies {
+		http.SetCookie(w, c)
+	}
 
+	w.Header().Set(resultHeader, resultAllowed)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deny writes the response of a failed authentication, browsers failing an interactive
+// authenticator are challenged again, errors are logged and never sent to clients
+func (a *API) deny(w http.ResponseWriter, r *http.Request, p *policy.Policy, err error, l string) {
+	authErr := new(AuthError)
+
+	if !errors.As(err, &authErr) {
+		a.log(r).Error(err)
+		a.denied(w, r, p, http.StatusInternalServerError, reasonInternalError, "")
 		return
 	}
 
-	if session != nil && *session.Active {
-		a.logger.Infof("[HTTP][allowed]: %s", l)
-		w.Header().Set(kubeflowHeader, session.GetIdentity().Id)
-		w.Header().Set(resultHeader, resultAllowed)
-		w.WriteHeader(http.StatusOK)
+	if c, ok := a.authenticators[authErr.Authenticator].(ChallengerInterface); ok && authErr.Status == http.StatusUnauthorized {
+		a.log(r).Infof("[HTTP][challenged][%s]: %v", authErr.Authenticator, authErr.Err)
+		a.challenge(c, w, r)
+		return
+	}
+
+	if authErr.Status >= http.StatusInternalServerError {
+		a.log(r).Errorf("[HTTP][error][%s]: %v", authErr.Authenticator, authErr.Err)
+	} else {
+		a.log(r).Infof("[HTTP][denied][%s]: %v %s", authErr.Authenticator, authErr.Err, l)
+	}
+
+	if authErr.Challenge != "" {
+		w.Header().Set(wwwAuthenticateHeader, a.challenges.header(authErr.Challenge))
+	}
+
+	a.denied(w, r, p, authErr.Status, reason(authErr.Status), "")
+}
+
+// challenge starts an interactive login, the response is always a denial as envoy lets through
+// any 2xx
+func (a *API) challenge(c ChallengerInterface, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(resultHeader, resultDenied)
+
+	c.Challenge(&challengeWriter{ResponseWriter: w}, r)
+}
+
+// challengeWriter turns a 2xx written by a challenger into a 401
+type challengeWriter struct {
+	http.ResponseWriter
 
+	wroteHeader bool
+}
+
+func (w *challengeWriter) WriteHeader(status int) {
+	if w.wroteHeader {
 		return
 	}
 
-	switch r.Header.Get(checkHeader) {
-	case allowedValue:
-		a.logger.Infof("[HTTP][allowed]: %s", l)
-		w.Header().Set(resultHeader, resultAllowed)
-		w.Header().Set(overrideHeader, r.Header.Get(overrideHeader))
-		w.Header().Set(receivedHeader, l)
-		w.WriteHeader(http.StatusOK)
-	default:
-		a.logger.Infof("[HTTP][denied]: %s", l)
-		w.Header().Set(resultHeader, resultDenied)
-		w.Header().Set(overrideHeader, r.Header.Get(overrideHeader))
-		w.Header().Set(receivedHeader, l)
-		w.WriteHeader(http.StatusForbidden)
-		_, _ = w.Write([]byte(denyBody))
+	w.wroteHeader = true
+
+	if status < http.StatusMultipleChoices {
+		status = http.StatusUnauthorized
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *challengeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusUnauthorized)
 	}
+
+	return w.ResponseWriter.Write(b)
 }
 
-func NewAPI(service ServiceInterface, logger logging.LoggerInterface) *API {
+func (a *API) exchangeToken(r *http.Request, p *policy.Policy, subjectToken string) (string, error) {
+	if a.exchanger == nil {
+		return "", fmt.Errorf("token exchange not configured")
+	}
+
+	return a.exchanger.Exchange(r.Context(), subjectToken, p.TokenExchange.Audience, p.TokenExchange.Scopes)
+}
+
+// Config carries the optional collaborators and settings of the check API, nil collaborators
+// disable the matching features
+type Config struct {
+	Limiter    ratelimit.LimiterInterface
+	Exchanger  TokenExchangerInterface
+	Rego       RegoEvaluatorInterface
+	Debug      *DebugConfig
+	Challenges *ChallengeConfig
+	// MaxBodyBytes is the largest body read on policies with read_body
+	MaxBodyBytes int64
+	// IdentityHeaders maps upstream headers to identity attributes, defaults to kubeflow-userid
+	IdentityHeaders map[string]string
+	// TrustedProxyHops is the number of proxies appending to X-Forwarded-For in front of the
+	// authorizer, 0 ignores the header
+	TrustedProxyHops int
+	// XFCCBy is the URI SAN of the proxy setting x-forwarded-client-cert, only the certificate
+	// it forwards is trusted, the header is ignored when empty
+	XFCCBy string
+}
+
+func NewAPI(policies PoliciesInterface, authenticators []AuthenticatorInterface, cfg *Config, logger logging.LoggerInterface) *API {
 	a := new(API)
 
-	a.service = service
+	if cfg == nil {
+		cfg = new(Config)
+	}
+
+	a.policies = policies
+	a.limiter = cfg.Limiter
+	a.exchanger = cfg.Exchanger
+	a.rego = cfg.Rego
+	a.debug = cfg.Debug
+	a.challenges = cfg.Challenges
+	a.maxBodyBytes = cfg.MaxBodyBytes
+	a.identityHeaders = cfg.IdentityHeaders
+	a.trustedHops = cfg.TrustedProxyHops
+	a.xfccBy = cfg.XFCCBy
 	a.logger = logger
 
+	a.authenticators = make(map[string]AuthenticatorInterface)
+
+	for _, authenticator := range authenticators {
+		a.authenticators[authenticator.Name()] = authenticator
+	}
+
 	return a
 }
@@ -9,12 +9,23 @@ import (
 
 	hClient "github.com/ory/hydra-client-go/v2"
 	kClient "github.com/ory/kratos-client-go"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/apikey"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+	"github.com/shipperizer/iam-ext-authz/pkg/opa"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/relyingparty"
+	"github.com/shipperizer/iam-ext-authz/pkg/serviceaccount"
 )
 
 type KratosClientInterface interface {
 	FrontendAPI() kClient.FrontendAPI
 }
 
+type KratosAdminClientInterface interface {
+	IdentityAPI() kClient.IdentityAPI
+}
+
 type HydraClientInterface interface {
 	OAuth2API() hClient.OAuth2API
 }
@@ -24,7 +35,58 @@ type AuthorizerInterface interface {
 }
 
 type ServiceInterface interface {
+	SessionToken([]*http.Cookie) string
 	CheckSession(context.Context, []*http.Cookie) (*kClient.Session, []*http.Cookie, error)
-	CheckToken(context.Context, string) (bool, string, error)
+	CheckSessionToken(context.Context, string) (*kClient.Session, error)
+	ExtendSession(context.Context, *kClient.Session, string, []*http.Cookie) (*kClient.Session, []*http.Cookie, error)
+	CheckToken(context.Context, string) (*hClient.IntrospectedOAuth2Token, error)
 	CreateBrowserLoginFlow(context.Context, string, string, string, bool, []*http.Cookie) (*kClient.LoginFlow, []*http.Cookie, error)
 }
+
+// PoliciesInterface returns the active policy set, it can change between two calls
+type PoliciesInterface interface {
+	Policies() *policy.Set
+}
+
+type RelyingPartyInterface interface {
+	AuthCodeURL(string) (string, *http.Cookie, error)
+	Session(*http.Request) *relyingparty.Session
+	Refresh(context.Context, *http.Request) (*relyingparty.Session, *http.Cookie, error)
+}
+
+type TokenExchangerInterface interface {
+	Exchange(context.Context, string, string, []string) (string, error)
+}
+
+type DPoPValidatorInterface interface {
+	Validate(context.Context, string, string, string, string) (string, error)
+}
+
+type APIKeyStoreInterface interface {
+	Authenticate(context.Context, string) (*apikey.Key, error)
+}
+
+// AuthenticatorInterface turns the credentials of a request into an identity, ErrNoCredentials
+// is returned when the request carries none of the credentials handled
+type AuthenticatorInterface interface {
+	Name() string
+	Authenticate(*http.Request, *policy.Policy) (*Identity, error)
+}
+
+// ChallengerInterface is implemented by authenticators able to start an interactive login
+type ChallengerInterface interface {
+	Challenge(http.ResponseWriter, *http.Request)
+}
+
+type RegoEvaluatorInterface interface {
+	Evaluate(context.Context, string, interface{}) (*opa.Decision, error)
+}
+
+type ServiceAccountValidatorInterface interface {
+	Issuer() string
+	Validate(context.Context, string) (*serviceaccount.Account, error)
+}
+
+type JWTVerifierInterface interface {
+	Verify(context.Context, string) (*oidc.Token, error)
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	kClient "github.com/ory/kratos-client-go"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+const sessionTokenHeader = "X-Session-Token"
+
+func sessionIdentity(session *kClient.Session, authenticator string) (*Identity, error) {
+	if session == nil || !session.GetActive() {
+		return nil, authError(http.StatusUnauthorized, "", fmt.Errorf("session not active"))
+	}
+
+	identity := new(Identity)
+	identity.Subject = session.GetIdentity().Id
+	identity.Authenticator = authenticator
+	identity.Session = session
+
+	if traits, ok := session.GetIdentity().Traits.(map[string]interface{}); ok {
+		identity.Claims = traits
+	}
+
+	return identity, nil
+}
+
+// extendSession slides the session of the identity when it is close to expiry, failures are
+// logged and the current session is kept as it is still valid
+func extendSession(r *http.Request, service ServiceInterface, identity *Identity, token string, cookies []*http.Cookie, logger logging.LoggerInterface) *Identity {
+	session, setCookies, err := service.ExtendSession(r.Context(), identity.Session, token, cookies)
+
+	if err != nil {
+		logging.FromContext(r.Context(), logger).Errorf("unable to extend session %s: %v", identity.Session.Id, err)
+	}
+
+	if session != nil {
+		identity.Session = session
+	}
+
+	// cookies set after the extension come last so that browsers keep them
+	identity.Cookies = append(identity.Cookies, setCookies...)
+
+	return identity
+}
+
+// KratosCookieAuthenticator validates the kratos browser session cookie, browsers without a
+// session are sent a new login flow
+type KratosCookieAuthenticator struct {
+	service ServiceInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *KratosCookieAuthenticator) Name() string {
+	return policy.AuthenticatorKratosCookie
+}
+
+func (a *KratosCookieAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	if a.service.SessionToken(r.Cookies()) == "" {
+		return nil, ErrNoCredentials
+	}
+
+	session, cookies, err := a.service.CheckSession(r.Context(), r.Cookies())
+
+	if errors.Is(err, ErrUnavailable) {
+		return nil, unavailable(err)
+	}
+
+	if err != nil {
+		return nil, authError(http.StatusUnauthorized, "", err)
+	}
+
+	identity, err := sessionIdentity(session, policy.AuthenticatorKratosCookie)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// kratos rotates the session and CSRF cookies, they are sent back on allowed requests
+	identity.Cookies = cookies
+
+	return extendSession(r, a.service, identity, a.service.SessionToken(r.Cookies()), r.Cookies(), a.logger), nil
+}
+
+// Challenge answers with a 401 carrying a new kratos browser login flow
+func (a *KratosCookieAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	loginChallenge := q.Get("login_challenge")
+
+	refresh, err := strconv.ParseBool(q.Get("refresh"))
+
+	refresh = refresh || !(err == nil)
+
+	returnTo := fmt.Sprintf("%s?login_challenge=%s", r.URL.Path, loginChallenge)
+
+	flow, cookies, err := a.service.CreateBrowserLoginFlow(r.Context(), q.Get("aal"), returnTo, loginChallenge, refresh, r.Cookies())
+	if err != nil {
+		http.Error(w, "Failed to create login flow", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := flow.MarshalJSON()
+
+	if err != nil {
+		logging.FromContext(r.Context(), a.logger).Errorf("Error when marshalling Json: %v\n", err)
+		http.Error(w, "Failed to marshall json", http.StatusInternalServerError)
+		return
+	}
+
+	for _, c := range cookies {
+		http.SetCookie(w, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(resp)
+}
+
+func NewKratosCookieAuthenticator(service ServiceInterface, logger logging.LoggerInterface) *KratosCookieAuthenticator {
+	a := new(KratosCookieAuthenticator)
+
+	a.service = service
+	a.logger = logger
+
+	return a
+}
+
+// KratosSessionTokenAuthenticator validates kratos session tokens sent by native apps in the
+// X-Session-Token header, or as bearer token when they carry the ory_st_ prefix
+type KratosSessionTokenAuthenticator struct {
+	service ServiceInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *KratosSessionTokenAuthenticator) Name() string {
+	return policy.AuthenticatorKratosSessionToken
+}
+
+func (a *KratosSessionTokenAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	token := strings.TrimSpace(r.Header.Get(sessionTokenHeader))
+
+	if scheme, bearer := authorizationCredentials(r); token == "" && scheme == bearerScheme && strings.HasPrefix(bearer, "ory_st_") {
+		token = bearer
+	}
+
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	session, err := a.service.CheckSessionToken(r.Context(), token)
+
+	if errors.Is(err, ErrUnavailable) {
+		return nil, unavailable(err)
+	}
+
+	if err != nil {
+		return nil, authError(http.StatusUnauthorized, "", err)
+	}
+
+	identity, err := sessionIdentity(session, policy.AuthenticatorKratosSessionToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return extendSession(r, a.service, identity, token, nil, a.logger), nil
+}
+
+func NewKratosSessionTokenAuthenticator(service ServiceInterface, logger logging.LoggerInterface) *KratosSessionTokenAuthenticator {
+	a := new(KratosSessionTokenAuthenticator)
+
+	a.service = service
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kClient "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+type fakeKratos struct {
+	ServiceInterface
+
+	session  *kClient.Session
+	cookies  []*http.Cookie
+	extended []*http.Cookie
+}
+
+func (f *fakeKratos) SessionToken(cookies []*http.Cookie) string {
+	for _, c := range cookies {
+		if c.Name == "ory_kratos_session" {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+func (f *fakeKratos) CheckSession(context.Context, []*http.Cookie) (*kClient.Session, []*http.Cookie, error) {
+	return f.session, f.cookies, nil
+}
+
+func (f *fakeKratos) ExtendSession(context.Context, *kClient.Session, string, []*http.Cookie) (*kClient.Session, []*http.Cookie, error) {
+	return nil, f.extended, nil
+}
+
+func TestKratosCookiesForwarded(t *testing.T) {
+	assert := assert.New(t)
+
+	session := kClient.NewSessionWithDefaults()
+	session.SetId("session")
+	session.SetActive(true)
+	session.SetExpiresAt(time.Now().Add(time.Hour))
+	session.SetIdentity(kClient.Identity{Id: "alice"})
+
+	service := &fakeKratos{
+		session:  session,
+		cookies:  []*http.Cookie{{Name: "csrf_token", Value: "rotated", Path: "/", HttpOnly: true}},
+		extended: []*http.Cookie{{Name: "ory_kratos_session", Value: "extended", Path: "/", HttpOnly: true}},
+	}
+
+	a := newChainAPI(
+		policy.Policy{Name: "ui", Authenticators: []string{policy.AuthenticatorKratosCookie}},
+		NewKratosCookieAuthenticator(service, logging.NewNoopLogger()),
+	)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+	r.AddCookie(&http.Cookie{Name: "ory_kratos_session", Value: "current"})
+
+	w := httptest.NewRecorder()
+	a.check(w, r)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		[]string{"csrf_token=rotated; Path=/; HttpOnly", "ory_kratos_session=extended; Path=/; HttpOnly"},
+		w.Header().Values("Set-Cookie"),
+	)
+}
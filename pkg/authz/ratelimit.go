@@ -0,0 +1,94 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+const (
+	rateLimitLimitHeader     = "X-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+	rateLimitResetHeader     = "X-RateLimit-Reset"
+	retryAfterHeader         = "Retry-After"
+)
+
+// withinRateLimits checks every rate limit of the policy, if one of them is exceeded a 429
+// is written and false returned, limiter failures are logged and the request let through
+func (a *API) withinRateLimits(w http.ResponseWriter, r *http.Request, p *policy.Policy, subject, clientID string) bool {
+	if p == nil || len(p.RateLimits) == 0 {
+		return true
+	}
+
+	if a.limiter == nil {
+		a.log(r).Errorf("policy %s has rate limits but no limiter is configured", p.Name)
+		return true
+	}
+
+	var tightest *ratelimit.Result
+
+	for _, rl := range p.RateLimits {
+		value := ""
+
+		switch rl.Key {
+		case policy.RateLimitIdentity:
+			value = subject
+		case policy.RateLimitClientID:
+			value = clientID
+		case policy.RateLimitIP:
+			value = a.clientIP(r)
+		case policy.RateLimitRoute:
+			value = p.Name
+		}
+
+		// the credential in use doesn't carry the attribute, limit doesn't apply
+		if value == "" {
+			continue
+		}
+
+		res, err := a.limiter.Allow(
+			r.Context(),
+			fmt.Sprintf("%s:%s:%s", p.Name, rl.Key, value),
+			ratelimit.Limit{Requests: rl.Requests, Period: rl.Period, Burst: rl.Burst},
+		)
+
+		if err != nil {
+			a.log(r).Errorf("rate limiter failure, letting request through: %v", err)
+			continue
+		}
+
+		if tightest == nil || !res.Allowed || (tightest.Allowed && res.Remaining < tightest.Remaining) {
+			tightest = res
+		}
+
+		if !res.Allowed {
+			break
+		}
+	}
+
+	if tightest == nil {
+		return true
+	}
+
+	w.Header().Set(rateLimitLimitHeader, strconv.Itoa(tightest.Limit))
+	w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(tightest.Remaining))
+	w.Header().Set(rateLimitResetHeader, strconv.Itoa(int(math.Ceil(tightest.Reset.Seconds()))))
+
+	if tightest.Allowed {
+		return true
+	}
+
+	a.log(r).Infof("[HTTP][rate limited]: %s %s%s subject: %s client: %s", originalMethod(r), OriginalHost(r), redactQuery(originalURI(r)), subject, clientID)
+
+	w.Header().Set(retryAfterHeader, strconv.Itoa(int(math.Ceil(tightest.RetryAfter.Seconds()))))
+	a.denied(w, r, p, http.StatusTooManyRequests, reasonRateLimited, "")
+
+	return false
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+func TestRateLimitedCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	apiKey := &fakeAuthenticator{name: policy.AuthenticatorAPIKey, identity: &Identity{Subject: "ci"}}
+
+	p := policy.Policy{
+		Name:           "api",
+		Authenticators: []string{policy.AuthenticatorAPIKey},
+		RateLimits:     []policy.RateLimit{{Key: policy.RateLimitIdentity, Requests: 1, Period: time.Hour}},
+	}
+
+	a := newChainAPI(p, apiKey)
+
+	w := httptest.NewRecorder()
+	a.check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check/orders", nil))
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("1", w.Header().Get(rateLimitLimitHeader))
+	assert.Equal("0", w.Header().Get(rateLimitRemainingHeader))
+	assert.Empty(w.Header().Get(retryAfterHeader))
+
+	w = httptest.NewRecorder()
+	a.check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check/orders", nil))
+
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal(resultDenied, w.Header().Get(resultHeader))
+	assert.Equal("1", w.Header().Get(rateLimitLimitHeader))
+	assert.Equal("0", w.Header().Get(rateLimitRemainingHeader))
+
+	retryAfter, err := strconv.Atoi(w.Header().Get(retryAfterHeader))
+	assert.Nil(err)
+	assert.True(retryAfter > 0 && retryAfter <= 3600, "retry after %d", retryAfter)
+
+	reset, err := strconv.Atoi(w.Header().Get(rateLimitResetHeader))
+	assert.Nil(err)
+	assert.True(reset > 0, "reset %d", reset)
+
+	// limits are kept per identity
+	apiKey.identity = &Identity{Subject: "other"}
+
+	w = httptest.NewRecorder()
+	a.check(w, httptest.NewRequest(http.MethodGet, "/api/v0/check/orders", nil))
+
+	assert.Equal(http.StatusOK, w.Code)
+}
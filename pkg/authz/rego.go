@@ -0,0 +1,139 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/opa"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// regoInput builds the input document of the rego query from the original request, the identity
+// and, when available, the kratos session and the token introspection result
+func regoInput(r *http.Request, ip string, p *policy.Policy, identity *Identity, body []byte) map[string]interface{} {
+	path, rawQuery, _ := strings.Cut(originalURI(r), "?")
+	query, _ := url.ParseQuery(rawQuery)
+
+	headers := make(map[string]string, len(r.Header))
+
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	request := map[string]interface{}{
+		"method":    originalMethod(r),
+		"host":      OriginalHost(r),
+		"path":      path,
+		"query":     query,
+		"headers":   headers,
+		"client_ip": ip,
+	}
+
+	if len(body) > 0 {
+		var document interface{}
+
+		if err := json.Unmarshal(body, &document); err == nil {
+			request["body"] = document
+		} else {
+			request["raw_body"] = string(body)
+		}
+	}
+
+	input := map[string]interface{}{
+		"policy":  p.Name,
+		"request": request,
+		"identity": map[string]interface{}{
+			"subject":       identity.Subject,
+			"username":      identity.Username,
+			"client_id":     identity.ClientID,
+			"scopes":        identity.Scopes,
+			"groups":        identity.Groups,
+			"claims":        identity.Claims,
+			"authenticator": identity.Authenticator,
+		},
+	}
+
+	if identity.Session != nil {
+		input["session"] = document(identity.Session)
+	}
+
+	if identity.Introspection != nil {
+		input["token"] = document(identity.Introspection)
+	}
+
+	return input
+}
+
+// document turns API models in plain JSON documents, as seen by rego
+func document(v interface{}) interface{} {
+	raw, err := json.Marshal(v)
+
+	if err != nil {
+		return nil
+	}
+
+	var d interface{}
+
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil
+	}
+
+	return d
+}
+
+// evaluateRego queries the policy decision, denials are written with the status, headers and
+// body of the decision, or the deny response of the policy, and nil returned
+func (a *API) evaluateRego(w http.ResponseWriter, r *http.Request, p *policy.Policy, identity *Identity, body []byte, l string) *opa.Decision {
+	if a.rego == nil {
+		a.log(r).Errorf("policy %s needs a rego decision but no rego modules are configured", p.Name)
+		a.denied(w, r, p, http.StatusInternalServerError, reasonInternalError, "")
+		return nil
+	}
+
+	d, err := a.rego.Evaluate(r.Context(), p.Rego.Decision, regoInput(r, a.clientIP(r), p, identity, body))
+
+	if err != nil {
+		a.log(r).Errorf("rego decision %s of policy %s failed: %v", p.Rego.Decision, p.Name, err)
+		a.denied(w, r, p, http.StatusInternalServerError, reasonInternalError, "")
+		return nil
+	}
+
+	if d.Allow {
+		return d
+	}
+
+	a.log(r).Infof("[HTTP][denied][rego]: decision %s of policy %s %s", p.Rego.Decision, p.Name, l)
+
+	for name, value := range d.Headers {
+		w.Header().Set(name, value)
+	}
+
+	status := d.Status
+
+	// like the deny responses of policies, envoy would let the request through on a 2xx
+	if status != 0 && (status < http.StatusBadRequest || status > 599) {
+		a.log(r).Errorf("rego decision %s of policy %s denied with status %d, using 403", p.Rego.Decision, p.Name, status)
+		status = 0
+	}
+
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+
+	// bodies of the decision win over the deny response of the policy
+	if d.Body == "" {
+		a.denied(w, r, p, status, reasonPolicyDenied, "")
+		return nil
+	}
+
+	w.Header().Set(resultHeader, resultDenied)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(d.Body))
+
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
+	"github.com/shipperizer/iam-ext-authz/pkg/opa"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+type fakeEvaluator struct {
+	decision *opa.Decision
+	input    map[string]interface{}
+}
+
+func (f *fakeEvaluator) Evaluate(_ context.Context, _ string, input interface{}) (*opa.Decision, error) {
+	f.input = input.(map[string]interface{})
+
+	return f.decision, nil
+}
+
+func TestRegoDecision(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision *opa.Decision
+		status   int
+		header   string
+		body     string
+	}{
+		{name: "allow", decision: &opa.Decision{Allow: true, Headers: map[string]string{"x-tier": "gold"}}, status: http.StatusOK, header: "gold"},
+		{name: "deny", decision: &opa.Decision{}, status: http.StatusForbidden, body: `"reason":"policy_denied"`},
+		{name: "custom deny", decision: &opa.Decision{Status: http.StatusPaymentRequired, Headers: map[string]string{"x-tier": "none"}, Body: "upgrade"}, status: http.StatusPaymentRequired, header: "none", body: "upgrade"},
+		{name: "deny with a success status", decision: &opa.Decision{Status: http.StatusOK, Body: "ok"}, status: http.StatusForbidden, body: "ok"},
+		{name: "deny with a redirect status", decision: &opa.Decision{Status: http.StatusFound}, status: http.StatusForbidden, body: `"reason":"policy_denied"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			evaluator := &fakeEvaluator{decision: test.decision}
+			p := policy.Policy{Name: "api", Authenticators: []string{policy.AuthenticatorAPIKey}, Rego: &policy.Rego{Decision: "authz/allow"}}
+
+			a := NewAPI(
+				policy.NewStore(&policy.Set{Policies: []policy.Policy{p}}, "test"),
+				[]AuthenticatorInterface{&fakeAuthenticator{name: policy.AuthenticatorAPIKey, identity: &Identity{Subject: "ci", Scopes: []string{"read"}}}},
+				&Config{Limiter: ratelimit.NewLocal(), Rego: evaluator, MaxBodyBytes: 1024},
+				logging.NewNoopLogger(),
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/check/orders?id=1", nil)
+
+			w := httptest.NewRecorder()
+			a.check(w, r)
+
+			assert.Equal(test.status, w.Code)
+			assert.Equal(test.header, w.Header().Get("x-tier"))
+			assert.Contains(w.Body.String(), test.body)
+
+			request := evaluator.input["request"].(map[string]interface{})
+			assert.Equal("/orders", request["path"])
+			assert.Equal("ci", evaluator.input["identity"].(map[string]interface{})["subject"])
+		})
+	}
+}
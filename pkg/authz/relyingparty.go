@@ -0,0 +1,69 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/relyingparty"
+)
+
+// RelyingPartyAuthenticator authenticates browsers from the relying party session cookie, when
+// missing the browser is sent to the authorization server
+type RelyingPartyAuthenticator struct {
+	relyingParty RelyingPartyInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *RelyingPartyAuthenticator) Name() string {
+	return policy.AuthenticatorRelyingParty
+}
+
+func (a *RelyingPartyAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	session := a.relyingParty.Session(r)
+
+	if session != nil {
+		return &Identity{Subject: session.Subject, Authenticator: policy.AuthenticatorRelyingParty}, nil
+	}
+
+	// expired sessions are renewed with the refresh token granted through offline_access
+	session, cookie, err := a.relyingParty.Refresh(r.Context(), r)
+
+	if err != nil {
+		if !errors.Is(err, relyingparty.ErrNoRefreshToken) {
+			logging.FromContext(r.Context(), a.logger).Infof("unable to refresh relying party session: %v", err)
+		}
+
+		return nil, ErrNoCredentials
+	}
+
+	return &Identity{Subject: session.Subject, Authenticator: policy.AuthenticatorRelyingParty, Cookies: []*http.Cookie{cookie}}, nil
+}
+
+// Challenge redirects the browser to the authorization endpoint
+func (a *RelyingPartyAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	authURL, cookie, err := a.relyingParty.AuthCodeURL(originalURL(r))
+
+	if err != nil {
+		logging.FromContext(r.Context(), a.logger).Errorf("unable to start authorization code flow: %v", err)
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func NewRelyingPartyAuthenticator(relyingParty RelyingPartyInterface, logger logging.LoggerInterface) *RelyingPartyAuthenticator {
+	a := new(RelyingPartyAuthenticator)
+
+	a.relyingParty = relyingParty
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+const (
+	checkPath = "/api/v0/check"
+	redacted  = "REDACTED"
+)
+
+// credentialHeaders carry secrets and are never logged
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "DPoP", sessionTokenHeader}
+
+// uriHeaders carry the original request URI, their query is never logged
+var uriHeaders = []string{"X-Forwarded-Uri", "X-Original-URI", "X-Original-URL", "X-Envoy-Original-Path"}
+
+// Original is the request being authorized
+type Original struct {
+	Method string
+	Host   string
+	// URI is the path and query of the request
+	URI    string
+	Scheme string
+}
+
+// original returns the request being authorized, the proxy adapters read it from the headers of
+// their proxy while envoy sends the original method and host and appends the original path to
+// the check path, forwarded headers sent by clients are never trusted on the envoy endpoints
+func original(r *http.Request) *Original {
+	if adapter, ok := proxyAdapters[r.URL.Path]; ok {
+		return adapter.Original(r)
+	}
+
+	o := new(Original)
+	o.Method = r.Method
+	o.Host = r.Host
+	o.URI = strings.TrimPrefix(r.URL.Path, checkPath)
+	o.Scheme = r.Header.Get("X-Forwarded-Proto")
+
+	if !strings.HasPrefix(o.URI, "/") {
+		o.URI = "/" + o.URI
+	}
+
+	if r.URL.RawQuery != "" {
+		o.URI = o.URI + "?" + r.URL.RawQuery
+	}
+
+	return o
+}
+
+// OriginalHost returns the host of the request being authorized, used to pick the tenant
+func OriginalHost(r *http.Request) string {
+	return original(r).Host
+}
+
+// originalMethod returns the method of the request being authorized
+func originalMethod(r *http.Request) string {
+	return original(r).Method
+}
+
+// originalURI returns path and query of the request being authorized
+func originalURI(r *http.Request) string {
+	return original(r).URI
+}
+
+// originalPath returns the decoded path of the request being authorized
+func originalPath(r *http.Request) string {
+	uri := originalURI(r)
+
+	if u, err := url.ParseRequestURI(uri); err == nil {
+		return u.Path
+	}
+
+	return strings.SplitN(uri, "?", 2)[0]
+}
+
+// originalURL returns the absolute URL of the request being authorized
+func originalURL(r *http.Request) string {
+	o := original(r)
+
+	scheme := o.Scheme
+
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	return scheme + "://" + o.Host + o.URI
+}
+
+// clientIP returns the address of the downstream client, envoy reports it in
+// X-Envoy-External-Address, otherwise it is the X-Forwarded-For entry appended by the furthest
+// of the trusted proxies, entries on its left are sent by the client and never trusted
+func clientIP(r *http.Request, trustedHops int) string {
+	if ip := r.Header.Get("X-Envoy-External-Address"); ip != "" {
+		return ip
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); trustedHops > 0 && len(xff) > 0 {
+		entries := strings.Split(strings.Join(xff, ","), ",")
+		i := len(entries) - trustedHops
+
+		if i < 0 {
+			i = 0
+		}
+
+		if ip := strings.TrimSpace(entries[i]); ip != "" {
+			return ip
+		}
+	}
+
+	return remoteIP(r)
+}
+
+// clientIP returns the address of the downstream client of the request
+func (a *API) clientIP(r *http.Request) string {
+	return clientIP(r, a.trustedHops)
+}
+
+// remoteIP returns the address of the peer calling the authorizer, the proxy itself
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// logLine describes the request for logs and the debug echo, credential headers, the API key
+// header of the policy and query values are redacted
+func logLine(r *http.Request, p *policy.Policy, bodyLength int) string {
+	headers := r.Header.Clone()
+
+	names := credentialHeaders
+
+	if p != nil && p.APIKey != nil && p.APIKey.Header != "" {
+		names = append([]string{p.APIKey.Header}, names...)
+	}
+
+	for _, name := range names {
+		if values := headers.Values(name); len(values) > 0 {
+			headers.Set(name, redacted)
+		}
+	}
+
+	for _, name := range uriHeaders {
+		if uri := headers.Get(name); uri != "" {
+			headers.Set(name, redactQuery(uri))
+		}
+	}
+
+	return fmt.Sprintf("%s %s%s, headers: %v, body: %d bytes\n", r.Method, r.Host, redactQuery(r.URL.RequestURI()), headers, bodyLength)
+}
+
+// redactQuery replaces the values of the query parameters of the URI, API keys and tokens can
+// be sent as query parameters
+func redactQuery(uri string) string {
+	base, rawQuery, found := strings.Cut(uri, "?")
+
+	if !found {
+		return uri
+	}
+
+	q, err := url.ParseQuery(rawQuery)
+
+	if err != nil {
+		return base + "?" + redacted
+	}
+
+	for _, values := range q {
+		for i := range values {
+			values[i] = redacted
+		}
+	}
+
+	return base + "?" + q.Encode()
+}
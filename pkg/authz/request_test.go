@@ -0,0 +1,67 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string][]string
+		hops     int
+		expected string
+	}{
+		{"envoy external address", map[string][]string{"X-Envoy-External-Address": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}}, 1, "203.0.113.7"},
+		{"spoofed entries are skipped", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}}, 1, "203.0.113.7"},
+		{"two hops", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7", "10.0.0.2"}}, 2, "203.0.113.7"},
+		{"more hops than entries", map[string][]string{"X-Forwarded-For": {"203.0.113.7"}}, 3, "203.0.113.7"},
+		{"header ignored without hops", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, 0, "192.0.2.1"},
+		{"remote address", nil, 1, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+
+			for name, values := range test.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+
+			assert.Equal(t, test.expected, clientIP(r, test.hops))
+		})
+	}
+}
+
+func TestLogLineRedactsCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v0/check/orders?api_key=secret-query&page=2", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Cookie", "ory_kratos_session=secret-cookie")
+	r.Header.Set("X-Session-Token", "secret-session")
+	r.Header.Set("DPoP", "secret-proof")
+	r.Header.Set("X-Api-Key", "secret-key")
+	r.Header.Set("X-Forwarded-Uri", "/orders?token=secret-uri")
+	r.Header.Set("Accept", "application/json")
+
+	l := logLine(r, &policy.Policy{APIKey: &policy.APIKeySource{Header: "X-Api-Key"}}, 0)
+
+	for _, secret := range []string{"secret-query", "secret-token", "secret-cookie", "secret-session", "secret-proof", "secret-key", "secret-uri"} {
+		assert.NotContains(l, secret)
+	}
+
+	assert.Contains(l, "/api/v0/check/orders?")
+	assert.Contains(l, "application/json")
+}
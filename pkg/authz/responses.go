@@ -0,0 +1,168 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// machine readable reason codes of denials
+const (
+	reasonAccessDenied     = "access_denied"
+	reasonUnauthenticated  = "unauthenticated"
+	reasonForbidden        = "forbidden"
+	reasonConditionsNotMet = "conditions_not_met"
+	reasonPolicyDenied     = "policy_denied"
+	reasonBadRequest       = "bad_request"
+	reasonBodyTooLarge     = "body_too_large"
+	reasonRateLimited      = "rate_limited"
+	reasonUnavailable      = "unavailable"
+	reasonInternalError    = "internal_error"
+)
+
+const requestIDHeader = "x-request-id"
+
+// denied writes the deny response of the policy, status and reason are the outcome of the check
+// while detail, when set, is a message safe to show to clients
+func (a *API) denied(w http.ResponseWriter, r *http.Request, p *policy.Policy, status int, reason, detail string) {
+	var deny *policy.DenyResponse
+
+	if p != nil {
+		deny = p.Deny
+	}
+
+	if deny != nil && deny.Status != 0 && status == http.StatusForbidden {
+		status = deny.Status
+	}
+
+	denial := policy.Denial{
+		Status:    status,
+		Reason:    reason,
+		Title:     http.StatusText(status),
+		Detail:    detail,
+		RequestID: requestID(r),
+	}
+
+	if p != nil {
+		denial.Policy = p.Name
+	}
+
+	format := negotiate(r.Header.Get("Accept"))
+	body, err := deny.Render(format, denial)
+
+	if err != nil {
+		a.log(r).Errorf("deny template of policy %s failed: %v", denial.Policy, err)
+		body, _ = (*policy.DenyResponse)(nil).Render(format, denial)
+	}
+
+	w.Header().Set("Content-Type", contentType(format, deny))
+
+	if deny != nil {
+		for name, value := range deny.Headers {
+			w.Header().Set(name, value)
+		}
+	}
+
+	w.Header().Set(resultHeader, resultDenied)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// reason maps the status of authentication errors to reason codes
+func reason(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return reasonUnauthenticated
+	case status == http.StatusForbidden:
+		return reasonForbidden
+	case status == http.StatusServiceUnavailable:
+		return reasonUnavailable
+	case status >= http.StatusInternalServerError:
+		return reasonInternalError
+	default:
+		return reasonAccessDenied
+	}
+}
+
+// requestID returns the id set by envoy, or the one generated by the request id middleware
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+
+	return middleware.GetReqID(r.Context())
+}
+
+// negotiate picks the deny body format from the Accept header of the client, problem+json is
+// the default
+func negotiate(accept string) string {
+	type mediaRange struct {
+		format string
+		q      float64
+	}
+
+	ranges := make([]mediaRange, 0)
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+
+		var format string
+
+		switch mediaType {
+		case "application/problem+json", "application/json", "application/*", "*/*":
+			format = policy.FormatJSON
+		case "text/html", "application/xhtml+xml":
+			format = policy.FormatHTML
+		case "text/plain", "text/*":
+			format = policy.FormatText
+		default:
+			continue
+		}
+
+		ranges = append(ranges, mediaRange{format: format, q: q})
+	}
+
+	if len(ranges) == 0 {
+		return policy.FormatJSON
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	return ranges[0].format
+}
+
+func contentType(format string, deny *policy.DenyResponse) string {
+	switch format {
+	case policy.FormatHTML:
+		return "text/html; charset=utf-8"
+	case policy.FormatText:
+		return "text/plain; charset=utf-8"
+	}
+
+	if deny != nil && deny.Body.JSON != "" {
+		return "application/json"
+	}
+
+	return "application/problem+json"
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+func TestDenyResponses(t *testing.T) {
+	deny := &policy.DenyResponse{
+		Status:  http.StatusNotFound,
+		Headers: map[string]string{"cache-control": "no-store"},
+		Body:    policy.DenyBody{Text: "nope ({{ .Reason }}, {{ .RequestID }})"},
+	}
+
+	tests := []struct {
+		name        string
+		deny        *policy.DenyResponse
+		err         error
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{name: "problem json by default", err: authError(http.StatusForbidden, "", fmt.Errorf("inactive")), status: http.StatusForbidden, contentType: "application/problem+json", body: `"reason":"forbidden","title":"Forbidden","request_id":"req-1"`},
+		{name: "html", err: authError(http.StatusUnauthorized, "", fmt.Errorf("expired")), accept: "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8", status: http.StatusUnauthorized, contentType: "text/html; charset=utf-8", body: "<p>Reason: unauthenticated</p>"},
+		{name: "internal errors are hidden", err: fmt.Errorf("kratos at 10.0.0.1 unreachable"), accept: "text/plain", status: http.StatusInternalServerError, contentType: "text/plain; charset=utf-8", body: "reason: internal_error"},
+		{name: "custom template and status", deny: deny, err: authError(http.StatusForbidden, "", fmt.Errorf("inactive")), accept: "text/plain", status: http.StatusNotFound, contentType: "text/plain; charset=utf-8", body: "nope (forbidden, req-1)"},
+		{name: "custom status only replaces forbidden", deny: deny, err: authError(http.StatusUnauthorized, "", fmt.Errorf("expired")), status: http.StatusUnauthorized, contentType: "application/problem+json", body: `"reason":"unauthenticated"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			p := policy.Policy{Name: "api", Authenticators: []string{policy.AuthenticatorJWT}, Deny: test.deny}
+			a := newChainAPI(p, &fakeAuthenticator{name: policy.AuthenticatorJWT, err: test.err})
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+			r.Header.Set("Accept", test.accept)
+			r.Header.Set(requestIDHeader, "req-1")
+
+			w := httptest.NewRecorder()
+			a.check(w, r)
+
+			assert.Equal(test.status, w.Code)
+			assert.Equal(test.contentType, w.Header().Get("Content-Type"))
+			assert.Contains(w.Body.String(), test.body)
+			assert.NotContains(w.Body.String(), "10.0.0.1")
+			assert.NotContains(w.Body.String(), "inactive")
+
+			if test.deny != nil {
+				assert.Equal("no-store", w.Header().Get("cache-control"))
+			}
+		})
+	}
+}
@@ -5,9 +5,13 @@ package authz
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	hClient "github.com/ory/hydra-client-go/v2"
 	kClient "github.com/ory/kratos-client-go"
 
 	"github.com/shipperizer/iam-ext-authz/internal/logging"
@@ -15,44 +19,133 @@ import (
 	"github.com/shipperizer/iam-ext-authz/internal/tracing"
 )
 
+// ErrUnavailable wraps the failures of kratos and hydra to answer, as opposed to rejections of
+// the credentials
+var ErrUnavailable = errors.New("upstream unavailable")
+
+// upstreamError marks network failures and 5xx responses as unavailability
+func upstreamError(resp *http.Response, err error) error {
+	if resp == nil || resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	return err
+}
+
 type Service struct {
 	kratos KratosClientInterface
 	hydra  HydraClientInterface
 
+	sessions *SessionCache
+	extender *SessionExtender
+
 	tracer  tracing.TracingInterface
 	monitor monitoring.MonitorInterface
 	logger  logging.LoggerInterface
 }
 
 func (s *Service) CheckSession(ctx context.Context, cookies []*http.Cookie) (*kClient.Session, []*http.Cookie, error) {
+	token := s.sessions.Token(cookies)
+
+	if session := s.sessions.Get(ctx, token); session != nil {
+		return session, nil, nil
+	}
+
+	fetchedAt := time.Now()
+
 	ctx, span := s.tracer.Start(ctx, "kratos.FrontendAPI.ToSession")
 	defer span.End()
 
-	strCookie := make([]string, 0)
+	session, resp, err := s.kratos.FrontendAPI().
+		ToSession(ctx).
+		Cookie(cookieHeader(cookies)).
+		Execute()
 
-	for _, c := range cookies {
-		strCookie = append(strCookie, c.String())
+	if err != nil {
+		return nil, nil, upstreamError(resp, err)
+	}
+
+	if session.GetActive() {
+		s.sessions.Store(ctx, token, session, fetchedAt)
+	}
+
+	return session, resp.Cookies(), nil
+}
+
+// ExtendSession slides the session when it is close to expiry, browsers then get the session
+// again from kratos, which reissues the cookie with the new expiry; nil is returned when the
+// session wasn't extended
+func (s *Service) ExtendSession(ctx context.Context, session *kClient.Session, token string, cookies []*http.Cookie) (*kClient.Session, []*http.Cookie, error) {
+	extended, err := s.extender.Extend(ctx, session)
+
+	if err != nil || extended == nil {
+		return nil, nil, err
+	}
+
+	fetchedAt := time.Now()
+
+	if len(cookies) == 0 {
+		s.sessions.Store(ctx, token, extended, fetchedAt)
+
+		return extended, nil, nil
+	}
+
+	ctx, span := s.tracer.Start(ctx, "kratos.FrontendAPI.ToSession")
+	defer span.End()
+
+	refreshed, resp, err := s.kratos.FrontendAPI().
+		ToSession(ctx).
+		Cookie(cookieHeader(cookies)).
+		Execute()
+
+	if err != nil {
+		return extended, nil, upstreamError(resp, err)
 	}
 
+	s.sessions.Store(ctx, token, refreshed, fetchedAt)
+
+	return refreshed, resp.Cookies(), nil
+}
+
+// SessionToken returns the kratos session cookie value, empty when missing
+func (s *Service) SessionToken(cookies []*http.Cookie) string {
+	return s.sessions.Token(cookies)
+}
+
+func (s *Service) CheckSessionToken(ctx context.Context, token string) (*kClient.Session, error) {
+	if session := s.sessions.Get(ctx, token); session != nil {
+		return session, nil
+	}
+
+	fetchedAt := time.Now()
+
+	ctx, span := s.tracer.Start(ctx, "kratos.FrontendAPI.ToSession")
+	defer span.End()
+
 	session, resp, err := s.kratos.FrontendAPI().
 		ToSession(ctx).
-		Cookie(strings.Join(strCookie, "; ")).
+		XSessionToken(token).
 		Execute()
 
 	if err != nil {
-		return nil, nil, err
+		return nil, upstreamError(resp, err)
 	}
-	return session, resp.Cookies(), nil
+
+	if session.GetActive() {
+		s.sessions.Store(ctx, token, session, fetchedAt)
+	}
+
+	return session, nil
 }
 
-func (s *Service) CheckToken(ctx context.Context, IDToken string) (bool, string, error) {
-	it, _, err := s.hydra.OAuth2API().IntrospectOAuth2Token(ctx).Token(IDToken).Execute()
+func (s *Service) CheckToken(ctx context.Context, IDToken string) (*hClient.IntrospectedOAuth2Token, error) {
+	it, resp, err := s.hydra.OAuth2API().IntrospectOAuth2Token(ctx).Token(IDToken).Execute()
 
 	if err != nil {
-		return false, "", err
+		return nil, upstreamError(resp, err)
 	}
 
-	return it.GetActive(), it.GetUsername(), nil
+	return it, nil
 }
 
 func (s *Service) CreateBrowserLoginFlow(
@@ -77,18 +170,20 @@ func (s *Service) CreateBrowserLoginFlow(
 		Execute()
 
 	if err != nil {
-		s.logger.Debugf("full HTTP response: %v", resp)
+		logging.FromContext(ctx, s.logger).Debugf("full HTTP response: %v", resp)
 		return nil, nil, err
 	}
 
 	return flow, resp.Cookies(), nil
 }
 
-func NewService(kratos KratosClientInterface, hydra HydraClientInterface, tracer tracing.TracingInterface, monitor monitoring.MonitorInterface, logger logging.LoggerInterface) *Service {
+func NewService(kratos KratosClientInterface, hydra HydraClientInterface, sessions *SessionCache, extender *SessionExtender, tracer tracing.TracingInterface, monitor monitoring.MonitorInterface, logger logging.LoggerInterface) *Service {
 	s := new(Service)
 
 	s.kratos = kratos
 	s.hydra = hydra
+	s.sessions = sessions
+	s.extender = extender
 
 	s.monitor = monitor
 	s.tracer = tracer
@@ -96,3 +191,13 @@ func NewService(kratos KratosClientInterface, hydra HydraClientInterface, tracer
 
 	return s
 }
+
+func cookieHeader(cookies []*http.Cookie) string {
+	values := make([]string, 0, len(cookies))
+
+	for _, c := range cookies {
+		values = append(values, c.String())
+	}
+
+	return strings.Join(values, "; ")
+}
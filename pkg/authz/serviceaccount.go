@@ -0,0 +1,78 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/serviceaccount"
+)
+
+// ServiceAccountAuthenticator validates projected kubernetes service account tokens, tokens of
+// other issuers are left to the next authenticator
+type ServiceAccountAuthenticator struct {
+	validator ServiceAccountValidatorInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *ServiceAccountAuthenticator) Name() string {
+	return policy.AuthenticatorServiceAccount
+}
+
+func (a *ServiceAccountAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	scheme, raw, err := accessToken(r)
+
+	if err != nil || scheme != bearerScheme || !oidc.IsJWT(raw) {
+		return nil, ErrNoCredentials
+	}
+
+	if issuer, err := oidc.UnverifiedIssuer(raw); err != nil || issuer != a.validator.Issuer() {
+		return nil, ErrNoCredentials
+	}
+
+	account, err := a.validator.Validate(r.Context(), raw)
+
+	if errors.Is(err, serviceaccount.ErrUnavailable) {
+		return nil, authError(http.StatusServiceUnavailable, "", err)
+	}
+
+	if errors.Is(err, serviceaccount.ErrInvalidToken) {
+		return nil, invalidToken(scheme, "the service account token is invalid or expired", err)
+	}
+
+	if err != nil {
+		return nil, authError(http.StatusInternalServerError, "", err)
+	}
+
+	if !p.AllowsServiceAccount(account.Username) {
+		return nil, authError(http.StatusForbidden, "", fmt.Errorf("service account %s not allowed", account.Username))
+	}
+
+	identity := new(Identity)
+	identity.Subject = account.Username
+	identity.Claims = map[string]interface{}{
+		"namespace":       account.Namespace,
+		"service_account": account.Name,
+		"uid":             account.UID,
+		"aud":             account.Audiences,
+	}
+	identity.Authenticator = policy.AuthenticatorServiceAccount
+
+	return identity, nil
+}
+
+func NewServiceAccountAuthenticator(validator ServiceAccountValidatorInterface, logger logging.LoggerInterface) *ServiceAccountAuthenticator {
+	a := new(ServiceAccountAuthenticator)
+
+	a.validator = validator
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/serviceaccount"
+)
+
+const clusterIssuer = "https://kubernetes.default.svc.cluster.local"
+
+type fakeServiceAccounts struct {
+	validated int
+}
+
+func (f *fakeServiceAccounts) Issuer() string {
+	return clusterIssuer
+}
+
+// Validate trusts the subject of the token, signatures are checked by the serviceaccount package
+func (f *fakeServiceAccounts) Validate(_ context.Context, raw string) (*serviceaccount.Account, error) {
+	f.validated++
+
+	parsed, _ := jwt.ParseSigned(raw, []jose.SignatureAlgorithm{jose.ES256})
+	claims := jwt.Claims{}
+	_ = parsed.UnsafeClaimsWithoutVerification(&claims)
+
+	return serviceaccount.ParseUsername(claims.Subject)
+}
+
+func signedToken(t *testing.T, issuer, subject string) string {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+
+	raw, err := jwt.Signed(signer).Claims(jwt.Claims{Issuer: issuer, Subject: subject}).Serialize()
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return raw
+}
+
+func TestServiceAccountAuthenticator(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		status    int
+		subject   string
+		validated int
+	}{
+		{name: "allowed", token: signedToken(t, clusterIssuer, "system:serviceaccount:shop:checkout"), status: http.StatusOK, subject: "system:serviceaccount:shop:checkout", validated: 1},
+		{name: "other namespace", token: signedToken(t, clusterIssuer, "system:serviceaccount:dev:checkout"), status: http.StatusForbidden, validated: 1},
+		{name: "other issuer", token: signedToken(t, "https://hydra.example.com/", "alice"), status: http.StatusOK, subject: "anonymous"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			validator := new(fakeServiceAccounts)
+			anonymous := &fakeAuthenticator{name: policy.AuthenticatorAnonymous, identity: &Identity{Subject: "anonymous"}}
+
+			a := newChainAPI(
+				policy.Policy{
+					Name:            "orders",
+					Authenticators:  []string{policy.AuthenticatorServiceAccount, policy.AuthenticatorAnonymous},
+					ServiceAccounts: []string{"system:serviceaccount:shop:*"},
+				},
+				NewServiceAccountAuthenticator(validator, logging.NewNoopLogger()), anonymous,
+			)
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+			r.Header.Set("Authorization", "Bearer "+test.token)
+
+			w := httptest.NewRecorder()
+			a.check(w, r)
+
+			assert.Equal(test.status, w.Code)
+			assert.Equal(test.subject, w.Header().Get(kubeflowHeader))
+			assert.Equal(test.validated, validator.validated)
+		})
+	}
+}
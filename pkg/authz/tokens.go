@@ -0,0 +1,197 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// accessToken returns scheme and value of the access token, only the bearer and DPoP schemes
+// are considered
+func accessToken(r *http.Request) (string, string, error) {
+	scheme, token := authorizationCredentials(r)
+
+	if token == "" || (scheme != bearerScheme && scheme != dpopScheme) {
+		return "", "", ErrNoCredentials
+	}
+
+	return scheme, token, nil
+}
+
+// verifyBindings checks the sender constraints in the cnf claim of the token against the DPoP
+// proof key and the client certificate
+func verifyBindings(r *http.Request, scheme, proofKey string, cnf map[string]interface{}) error {
+	if err := verifyTokenBinding(scheme, proofKey, cnf); err != nil {
+		return err
+	}
+
+	return verifyCertificateBinding(scheme, clientCertificate(r), cnf)
+}
+
+// requireScopes checks the identity was granted every scope of the policy
+func requireScopes(r *http.Request, p *policy.Policy, identity *Identity) error {
+	if p == nil || len(p.Scopes) == 0 {
+		return nil
+	}
+
+	for _, scope := range p.Scopes {
+		if !slices.Contains(identity.Scopes, scope) {
+			scheme, _ := authorizationCredentials(r)
+
+			return insufficientScope(scheme, p.Scopes)
+		}
+	}
+
+	return nil
+}
+
+// IntrospectionAuthenticator validates opaque or JWT access tokens through hydra introspection
+type IntrospectionAuthenticator struct {
+	service ServiceInterface
+	dpop    DPoPValidatorInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *IntrospectionAuthenticator) Name() string {
+	return policy.AuthenticatorIntrospection
+}
+
+func (a *IntrospectionAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	scheme, raw, err := accessToken(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the proof is checked before introspection, no need to hit hydra for replayed proofs
+	proofKey, err := verifyDPoPProof(r, p, a.dpop, scheme, raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := a.service.CheckToken(r.Context(), raw)
+
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	if !token.GetActive() {
+		return nil, invalidToken(scheme, "the access token is inactive or expired", fmt.Errorf("token not active"))
+	}
+
+	if err := verifyBindings(r, scheme, proofKey, confirmation(token, raw)); err != nil {
+		return nil, err
+	}
+
+	identity := new(Identity)
+	identity.Subject = token.GetSub()
+	identity.Username = token.GetUsername()
+	identity.ClientID = token.GetClientId()
+	identity.Scopes = strings.Fields(token.GetScope())
+	identity.Claims = token.Ext
+	identity.Authenticator = policy.AuthenticatorIntrospection
+	identity.Token = raw
+	identity.Introspection = token
+
+	return identity, nil
+}
+
+func NewIntrospectionAuthenticator(service ServiceInterface, dpop DPoPValidatorInterface, logger logging.LoggerInterface) *IntrospectionAuthenticator {
+	a := new(IntrospectionAuthenticator)
+
+	a.service = service
+	a.dpop = dpop
+	a.logger = logger
+
+	return a
+}
+
+// JWTAuthenticator validates JWT access tokens locally against the key set of their issuer,
+// opaque tokens and tokens of untrusted issuers are left to the next authenticator
+type JWTAuthenticator struct {
+	verifier JWTVerifierInterface
+	dpop     DPoPValidatorInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *JWTAuthenticator) Name() string {
+	return policy.AuthenticatorJWT
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request, p *policy.Policy) (*Identity, error) {
+	scheme, raw, err := accessToken(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !oidc.IsJWT(raw) {
+		return nil, ErrNoCredentials
+	}
+
+	token, err := a.verifier.Verify(r.Context(), raw)
+
+	// tokens of other issuers are left to the next authenticator, the DPoP proof is checked
+	// only once the issuer is known so that it is not consumed here
+	if errors.Is(err, oidc.ErrUnknownIssuer) {
+		return nil, ErrNoCredentials
+	}
+
+	if errors.Is(err, oidc.ErrKeysUnavailable) {
+		return nil, authError(http.StatusServiceUnavailable, "", err)
+	}
+
+	if err != nil {
+		return nil, invalidToken(scheme, "the access token is invalid or expired", err)
+	}
+
+	// tokens of issuers not allowed on the route are left to the next authenticator as well
+	if p != nil && len(p.Issuers) > 0 && !slices.Contains(p.Issuers, token.Issuer) {
+		return nil, ErrNoCredentials
+	}
+
+	proofKey, err := verifyDPoPProof(r, p, a.dpop, scheme, raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cnf, _ := token.Claims["cnf"].(map[string]interface{})
+
+	if err := verifyBindings(r, scheme, proofKey, cnf); err != nil {
+		return nil, err
+	}
+
+	identity := new(Identity)
+	identity.Subject = token.Subject
+	identity.ClientID = token.ClientID
+	identity.Scopes = token.Scopes
+	identity.Groups = token.Groups
+	identity.Claims = token.Claims
+	identity.Authenticator = policy.AuthenticatorJWT
+	identity.Token = raw
+
+	return identity, nil
+}
+
+func NewJWTAuthenticator(verifier JWTVerifierInterface, dpop DPoPValidatorInterface, logger logging.LoggerInterface) *JWTAuthenticator {
+	a := new(JWTAuthenticator)
+
+	a.verifier = verifier
+	a.dpop = dpop
+	a.logger = logger
+
+	return a
+}
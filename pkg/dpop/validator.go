@@ -0,0 +1,185 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const (
+	proofType = "dpop+jwt"
+
+	replayKeyPrefix = "dpop:jti:"
+)
+
+// ErrInvalidProof wraps every validation failure of the DPoP proof
+var ErrInvalidProof = errors.New("invalid DPoP proof")
+
+// SupportedAlgorithms lists the asymmetric algorithms accepted for proofs
+var SupportedAlgorithms = []jose.SignatureAlgorithm{
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.EdDSA,
+}
+
+type proofClaims struct {
+	ID              string `json:"jti"`
+	Method          string `json:"htm"`
+	URL             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath"`
+}
+
+// Validator checks DPoP proofs as described by RFC 9449 section 4.3
+type Validator struct {
+	maxAge time.Duration
+	leeway time.Duration
+
+	replay cache.CacheInterface
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// Validate verifies the proof against the original request method and URL and the access
+// token it accompanies, the returned value is the JWK SHA-256 thumbprint of the proof key
+func (v *Validator) Validate(ctx context.Context, proof, method, requestURL, accessToken string) (string, error) {
+	ctx, span := v.tracer.Start(ctx, "dpop.Validator.Validate")
+	defer span.End()
+
+	token, err := jwt.ParseSigned(proof, SupportedAlgorithms)
+
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	if len(token.Headers) != 1 {
+		return "", fmt.Errorf("%w: exactly one signature expected", ErrInvalidProof)
+	}
+
+	header := token.Headers[0]
+
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != proofType {
+		return "", fmt.Errorf("%w: typ must be %s", ErrInvalidProof, proofType)
+	}
+
+	jwk := header.JSONWebKey
+
+	if jwk == nil || !jwk.IsPublic() || !jwk.Valid() {
+		return "", fmt.Errorf("%w: missing or invalid public jwk", ErrInvalidProof)
+	}
+
+	claims := new(proofClaims)
+
+	if err := token.Claims(jwk.Key, claims); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	if claims.ID == "" {
+		return "", fmt.Errorf("%w: jti is required", ErrInvalidProof)
+	}
+
+	if !strings.EqualFold(claims.Method, method) {
+		return "", fmt.Errorf("%w: htm doesn't match request method", ErrInvalidProof)
+	}
+
+	if !sameURL(claims.URL, requestURL) {
+		return "", fmt.Errorf("%w: htu doesn't match request URL", ErrInvalidProof)
+	}
+
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+
+	if time.Since(issuedAt) > v.maxAge || time.Until(issuedAt) > v.leeway {
+		return "", fmt.Errorf("%w: iat outside of the acceptable window", ErrInvalidProof)
+	}
+
+	if claims.AccessTokenHash != accessTokenHash(accessToken) {
+		return "", fmt.Errorf("%w: ath doesn't match access token", ErrInvalidProof)
+	}
+
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	// jti only needs to be remembered for as long as the proof would be accepted
+	fresh, err := v.replay.SetIfAbsent(ctx, replayKeyPrefix+jkt+":"+claims.ID, []byte{1}, v.maxAge+v.leeway)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to check DPoP proof replay: %w", err)
+	}
+
+	if !fresh {
+		return "", fmt.Errorf("%w: jti already used", ErrInvalidProof)
+	}
+
+	return jkt, nil
+}
+
+// sameURL compares htu with the request URL ignoring query and fragment, scheme and host
+// are compared case insensitively
+func sameURL(htu, requestURL string) bool {
+	a, err := url.Parse(htu)
+
+	if err != nil {
+		return false
+	}
+
+	b, err := url.Parse(requestURL)
+
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
+
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AlgorithmsChallenge returns the value of the algs parameter for the DPoP challenge
+func AlgorithmsChallenge() string {
+	algs := make([]string, 0, len(SupportedAlgorithms))
+
+	for _, alg := range SupportedAlgorithms {
+		algs = append(algs, string(alg))
+	}
+
+	return strings.Join(algs, " ")
+}
+
+func NewValidator(maxAge, leeway time.Duration, replay cache.CacheInterface, tracer tracing.TracingInterface, logger logging.LoggerInterface) *Validator {
+	v := new(Validator)
+
+	v.maxAge = maxAge
+	v.leeway = leeway
+	v.replay = replay
+
+	v.tracer = tracer
+	v.logger = logger
+
+	return v
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package dpop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+func newProof(t *testing.T, key *ecdsa.PrivateKey, claims proofClaims) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType(proofType),
+	)
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	proof, err := jwt.Signed(signer).Claims(claims).Serialize()
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return proof
+}
+
+func newValidator() *Validator {
+	return NewValidator(time.Minute, 5*time.Second, cache.NewMemory(), tracing.NewNoopTracer(), logging.NewNoopLogger())
+}
+
+func TestValidateProof(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	proof := newProof(t, key, proofClaims{
+		ID:              "jti-1",
+		Method:          "POST",
+		URL:             "https://api.example.com/orders",
+		IssuedAt:        time.Now().Unix(),
+		AccessTokenHash: accessTokenHash("access-token"),
+	})
+
+	v := newValidator()
+
+	jkt, err := v.Validate(context.TODO(), proof, "POST", "https://API.example.com/orders", "access-token")
+	assert.Nil(err)
+	assert.NotEmpty(jkt)
+
+	_, err = v.Validate(context.TODO(), proof, "POST", "https://api.example.com/orders", "access-token")
+	assert.ErrorIs(err, ErrInvalidProof, "replayed proof should be rejected")
+}
+
+func TestValidateProofMismatches(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	base := proofClaims{
+		Method:          "GET",
+		URL:             "https://api.example.com/orders",
+		IssuedAt:        time.Now().Unix(),
+		AccessTokenHash: accessTokenHash("access-token"),
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*proofClaims)
+	}{
+		{"method", func(c *proofClaims) { c.Method = "DELETE" }},
+		{"url", func(c *proofClaims) { c.URL = "https://api.example.com/other" }},
+		{"stale", func(c *proofClaims) { c.IssuedAt = time.Now().Add(-time.Hour).Unix() }},
+		{"future", func(c *proofClaims) { c.IssuedAt = time.Now().Add(time.Hour).Unix() }},
+		{"ath", func(c *proofClaims) { c.AccessTokenHash = accessTokenHash("other-token") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := base
+			claims.ID = test.name
+			test.mutate(&claims)
+
+			_, err := newValidator().Validate(context.TODO(), newProof(t, key, claims), "GET", "https://api.example.com/orders", "access-token")
+
+			assert.ErrorIs(t, err, ErrInvalidProof)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// TrustedIssuer is an issuer whose JWT access tokens are accepted
+type TrustedIssuer struct {
+	Issuer string `json:"issuer" yaml:"issuer"`
+	// DiscoveryURL defaults to the openid-configuration document of the issuer
+	DiscoveryURL string `json:"discovery_url,omitempty" yaml:"discovery_url"`
+	// JWKSURL skips discovery when set
+	JWKSURL   string   `json:"jwks_url,omitempty" yaml:"jwks_url"`
+	Audiences []string `json:"audiences,omitempty" yaml:"audiences"`
+	// SubjectClaim is the dot separated path of the claim used as subject, defaults to sub
+	SubjectClaim string `json:"subject_claim,omitempty" yaml:"subject_claim"`
+	// GroupsClaim is the dot separated path of the claim holding the groups of the subject
+	GroupsClaim string `json:"groups_claim,omitempty" yaml:"groups_claim"`
+	// GroupMapping maps the groups of the issuer to local groups, unmapped groups are dropped,
+	// groups are kept as they are when empty
+	GroupMapping map[string]string `json:"group_mapping,omitempty" yaml:"group_mapping"`
+	// Default marks the issuer of the deployment, its subjects are kept as they are while the
+	// ones of other issuers are namespaced as issuer|subject so they can't collide
+	Default bool `json:"-" yaml:"-"`
+}
+
+// IssuersConfig is the file format of the trusted issuers
+type IssuersConfig struct {
+	Issuers []TrustedIssuer `json:"issuers" yaml:"issuers"`
+}
+
+// LoadIssuers reads the trusted issuers from a YAML (or JSON) file, an empty path returns nil
+func LoadIssuers(path string) ([]TrustedIssuer, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trusted issuers: %w", err)
+	}
+
+	c := new(IssuersConfig)
+
+	if err := yaml.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("unable to parse trusted issuers: %w", err)
+	}
+
+	for _, t := range c.Issuers {
+		if len(t.Audiences) == 0 {
+			return nil, fmt.Errorf("issuer %s: audiences are required", t.Issuer)
+		}
+	}
+
+	return c.Issuers, nil
+}
+
+type trustedVerifier struct {
+	verifier *Verifier
+	config   TrustedIssuer
+}
+
+// mapClaims sets subject and groups of the token from the claims configured for the issuer
+func (t *trustedVerifier) mapClaims(token *Token) error {
+	if claim := t.config.SubjectClaim; claim != "" && claim != "sub" {
+		subject, _ := lookupClaim(token.Claims, claim).(string)
+
+		if subject == "" {
+			return fmt.Errorf("%w: claim %s is missing", ErrInvalidToken, claim)
+		}
+
+		token.Subject = subject
+	}
+
+	if !t.config.Default {
+		token.Subject = t.config.Issuer + "|" + token.Subject
+	}
+
+	if t.config.GroupsClaim == "" {
+		return nil
+	}
+
+	for _, group := range stringsClaim(lookupClaim(token.Claims, t.config.GroupsClaim)) {
+		if len(t.config.GroupMapping) == 0 {
+			token.Groups = append(token.Groups, group)
+			continue
+		}
+
+		if mapped, ok := t.config.GroupMapping[group]; ok {
+			token.Groups = append(token.Groups, mapped)
+		}
+	}
+
+	return nil
+}
+
+// Issuers routes JWTs to the verifier of the issuer in their iss claim
+type Issuers struct {
+	verifiers map[string]*trustedVerifier
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// Verify validates the JWT with the verifier of its issuer, ErrUnknownIssuer is returned when
+// the issuer is not trusted
+func (i *Issuers) Verify(ctx context.Context, raw string) (*Token, error) {
+	ctx, span := i.tracer.Start(ctx, "oidc.Issuers.Verify")
+	defer span.End()
+
+	issuer, err := UnverifiedIssuer(raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	trusted, ok := i.verifiers[issuer]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIssuer, issuer)
+	}
+
+	token, err := trusted.verifier.Verify(ctx, raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := trusted.mapClaims(token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var current interface{} = claims
+
+	for _, key := range strings.Split(path, ".") {
+		c, ok := current.(map[string]interface{})
+
+		if !ok {
+			return nil
+		}
+
+		current = c[key]
+	}
+
+	return current
+}
+
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		s := make([]string, 0, len(v))
+
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				s = append(s, str)
+			}
+		}
+
+		return s
+	}
+
+	return nil
+}
+
+// Names returns the trusted issuers, sorted
+func (i *Issuers) Names() []string {
+	names := make([]string, 0, len(i.verifiers))
+
+	for name := range i.verifiers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// NewIssuers builds a verifier for each trusted issuer, key sets are discovered on first use
+// unless their URL is configured
+func NewIssuers(trusted []TrustedIssuer, client *http.Client, tracer tracing.TracingInterface, logger logging.LoggerInterface) (*Issuers, error) {
+	i := new(Issuers)
+
+	i.verifiers = make(map[string]*trustedVerifier)
+	i.tracer = tracer
+	i.logger = logger
+
+	for _, t := range trusted {
+		if t.Issuer == "" {
+			return nil, fmt.Errorf("issuer is required")
+		}
+
+		if _, ok := i.verifiers[t.Issuer]; ok {
+			return nil, fmt.Errorf("duplicate issuer %s", t.Issuer)
+		}
+
+		var keys *KeySet
+
+		if t.JWKSURL != "" {
+			keys = NewKeySet(t.JWKSURL, client, tracer, logger)
+		} else {
+			discovery := t.DiscoveryURL
+
+			if discovery == "" {
+				discovery = strings.TrimSuffix(t.Issuer, "/") + discoveryPath
+			}
+
+			keys = NewDiscoveredKeySet(discovery, t.Issuer, client, tracer, logger)
+		}
+
+		i.verifiers[t.Issuer] = &trustedVerifier{
+			verifier: NewVerifier(t.Issuer, t.Audiences, keys, tracer, logger),
+			config:   t,
+		}
+	}
+
+	return i, nil
+}
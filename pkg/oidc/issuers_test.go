@@ -0,0 +1,162 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+// newIssuerServer serves discovery document and key set of an issuer rooted at the server URL
+func newIssuerServer(key *ecdsa.PrivateKey, kid string) *httptest.Server {
+	keys := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"}}}
+
+	var srv *httptest.Server
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case discoveryPath:
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": srv.URL, "jwks_uri": srv.URL + "/keys"})
+		case "/keys":
+			_ = json.NewEncoder(w).Encode(keys)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return srv
+}
+
+func newTestIssuers(t *testing.T, trusted ...TrustedIssuer) *Issuers {
+	issuers, err := NewIssuers(trusted, http.DefaultClient, tracing.NewNoopTracer(), logging.NewNoopLogger())
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return issuers
+}
+
+func TestIssuersVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	partner := newIssuerServer(key, "p1")
+	defer partner.Close()
+
+	hydraKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hydra := newJWKSServer(hydraKey, "h1")
+	defer hydra.Close()
+
+	issuers := newTestIssuers(t,
+		TrustedIssuer{Issuer: testIssuer, JWKSURL: hydra.URL, Default: true},
+		TrustedIssuer{
+			Issuer:       partner.URL,
+			Audiences:    []string{"orders"},
+			SubjectClaim: "email",
+			GroupsClaim:  "realm_access.roles",
+			GroupMapping: map[string]string{"partner-admins": "orders-admin"},
+		},
+	)
+
+	expiry := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	token, err := issuers.Verify(context.TODO(), newSignedToken(t, key, "p1", jwt.Claims{
+		Issuer: partner.URL, Subject: "1234", Audience: jwt.Audience{"orders"}, Expiry: expiry,
+	}, map[string]interface{}{
+		"email":        "bob@partner.com",
+		"realm_access": map[string]interface{}{"roles": []string{"partner-admins", "partner-users"}},
+	}))
+
+	assert.Nil(err)
+	assert.Equal(partner.URL+"|bob@partner.com", token.Subject, "subjects of other issuers are namespaced")
+	assert.Equal([]string{"orders-admin"}, token.Groups)
+
+	token, err = issuers.Verify(context.TODO(), newSignedToken(t, hydraKey, "h1", jwt.Claims{
+		Issuer: testIssuer, Subject: "alice", Expiry: expiry,
+	}, nil))
+
+	assert.Nil(err)
+	assert.Equal("alice", token.Subject)
+	assert.Nil(token.Groups)
+
+	// keys of an issuer don't validate tokens of another one
+	_, err = issuers.Verify(context.TODO(), newSignedToken(t, hydraKey, "h1", jwt.Claims{
+		Issuer: partner.URL, Subject: "1234", Audience: jwt.Audience{"orders"}, Expiry: expiry,
+	}, map[string]interface{}{"email": "bob@partner.com"}))
+
+	assert.True(errors.Is(err, ErrInvalidToken))
+
+	_, err = issuers.Verify(context.TODO(), newSignedToken(t, key, "p1", jwt.Claims{
+		Issuer: partner.URL, Subject: "1234", Audience: jwt.Audience{"orders"}, Expiry: expiry,
+	}, nil))
+
+	assert.True(errors.Is(err, ErrInvalidToken), "missing subject claim")
+
+	_, err = issuers.Verify(context.TODO(), newSignedToken(t, key, "p1", jwt.Claims{
+		Issuer: "https://unknown.example.com", Subject: "1234", Expiry: expiry,
+	}, nil))
+
+	assert.True(errors.Is(err, ErrUnknownIssuer))
+}
+
+func TestIssuersDiscoveryMismatch(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv := newIssuerServer(key, "p1")
+	defer srv.Close()
+
+	// the discovery document is served for another issuer
+	issuers := newTestIssuers(t, TrustedIssuer{
+		Issuer: "https://partner.example.com", DiscoveryURL: srv.URL + discoveryPath, Audiences: []string{"orders"},
+	})
+
+	_, err := issuers.Verify(context.TODO(), newSignedToken(t, key, "p1", jwt.Claims{
+		Issuer: "https://partner.example.com", Audience: jwt.Audience{"orders"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, nil))
+
+	if !errors.Is(err, ErrKeysUnavailable) {
+		t.Fatalf("expected ErrKeysUnavailable got %v", err)
+	}
+}
+
+func TestLoadIssuers(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "issuers.yaml")
+
+	_ = os.WriteFile(path, []byte("issuers:\n- issuer: https://partner.example.com\n  audiences: [orders]\n  groups_claim: groups\n"), 0o600)
+
+	trusted, err := LoadIssuers(path)
+
+	assert.Nil(err)
+	assert.Len(trusted, 1)
+	assert.Equal("groups", trusted[0].GroupsClaim)
+
+	_ = os.WriteFile(path, []byte("issuers:\n- issuer: https://partner.example.com\n"), 0o600)
+
+	_, err = LoadIssuers(path)
+
+	assert.NotNil(err, "audiences are required")
+
+	_, err = NewIssuers([]TrustedIssuer{{Issuer: testIssuer}, {Issuer: testIssuer}}, http.DefaultClient, tracing.NewNoopTracer(), logging.NewNoopLogger())
+
+	assert.NotNil(err, "duplicate issuer")
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const (
+	// jwksRefreshInterval is how long a fetched key set is trusted before being refreshed
+	jwksRefreshInterval = 15 * time.Minute
+	// jwksMinRefreshInterval throttles refreshes triggered by unknown key IDs
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+// KeySet fetches and caches a JSON Web Key Set, keys are refreshed periodically and when a
+// token signed with an unknown key ID shows up, to follow key rotations
+type KeySet struct {
+	url    string
+	client *http.Client
+
+	// discovery is the openid-configuration document the key set URL is read from, when the
+	// URL is not known upfront
+	discovery string
+	issuer    string
+
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+	mu        sync.Mutex
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// Keys returns the keys matching the key ID, an empty key ID returns all the keys
+func (k *KeySet) Keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	age := time.Since(k.fetchedAt)
+
+	if k.keys == nil || age > jwksRefreshInterval {
+		if err := k.refresh(ctx); err != nil && k.keys == nil {
+			return nil, err
+		}
+	}
+
+	keys := k.lookup(kid)
+
+	if len(keys) == 0 && kid != "" && time.Since(k.fetchedAt) > jwksMinRefreshInterval {
+		if err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		keys = k.lookup(kid)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key found for kid %q", kid)
+	}
+
+	return keys, nil
+}
+
+func (k *KeySet) lookup(kid string) []jose.JSONWebKey {
+	if k.keys == nil {
+		return nil
+	}
+
+	if kid == "" {
+		return k.keys.Keys
+	}
+
+	return k.keys.Key(kid)
+}
+
+func (k *KeySet) refresh(ctx context.Context) error {
+	ctx, span := k.tracer.Start(ctx, "oidc.KeySet.refresh")
+	defer span.End()
+
+	if k.url == "" {
+		if err := k.discover(ctx); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+
+	if err != nil {
+		k.logger.Errorf("unable to fetch JWKS from %s: %v", k.url, err)
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status %d", ErrKeysUnavailable, resp.StatusCode)
+	}
+
+	keys := new(jose.JSONWebKeySet)
+
+	if err := json.NewDecoder(resp.Body).Decode(keys); err != nil {
+		return fmt.Errorf("%w: invalid JWKS: %v", ErrKeysUnavailable, err)
+	}
+
+	k.keys = keys
+	k.fetchedAt = time.Now()
+
+	return nil
+}
+
+// discover reads the key set URL from the discovery document, the document must belong to
+// the expected issuer
+func (k *KeySet) discover(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.discovery, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.client.Do(req)
+
+	if err != nil {
+		k.logger.Errorf("unable to fetch discovery document from %s: %v", k.discovery, err)
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: discovery status %d", ErrKeysUnavailable, resp.StatusCode)
+	}
+
+	doc := struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("%w: invalid discovery document: %v", ErrKeysUnavailable, err)
+	}
+
+	if doc.Issuer != k.issuer || doc.JWKSURI == "" {
+		k.logger.Errorf("discovery document %s doesn't match issuer %s", k.discovery, k.issuer)
+		return fmt.Errorf("%w: discovery document doesn't match issuer %s", ErrKeysUnavailable, k.issuer)
+	}
+
+	k.url = doc.JWKSURI
+
+	return nil
+}
+
+func NewKeySet(url string, client *http.Client, tracer tracing.TracingInterface, logger logging.LoggerInterface) *KeySet {
+	k := new(KeySet)
+
+	k.url = url
+	k.client = client
+
+	k.tracer = tracer
+	k.logger = logger
+
+	return k
+}
+
+// NewDiscoveredKeySet returns a key set whose URL is read from the discovery document of the
+// issuer on first use
+func NewDiscoveredKeySet(discovery, issuer string, client *http.Client, tracer tracing.TracingInterface, logger logging.LoggerInterface) *KeySet {
+	k := NewKeySet("", client, tracer, logger)
+
+	k.discovery = discovery
+	k.issuer = issuer
+
+	return k
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const leeway = 30 * time.Second
+
+// ErrInvalidToken wraps every validation failure of the token
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrKeysUnavailable is returned when the key set of the issuer can't be fetched, tokens can't
+// be judged until it is back
+var ErrKeysUnavailable = errors.New("key set unavailable")
+
+// ErrUnknownIssuer is returned when no trusted issuer matches the iss claim of the token
+var ErrUnknownIssuer = errors.New("unknown issuer")
+
+// SignatureAlgorithms lists the algorithms accepted on JWTs
+var SignatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Token is a verified JWT
+type Token struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	ClientID string
+	Scopes   []string
+	// Groups are mapped from the groups claim configured for the issuer
+	Groups    []string
+	ExpiresAt time.Time
+	Claims    map[string]interface{}
+}
+
+// Verifier validates JWTs issued by a single issuer and signed with keys of its key set
+type Verifier struct {
+	issuer    string
+	audiences []string
+
+	keys *KeySet
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+func (v *Verifier) Issuer() string {
+	return v.issuer
+}
+
+// Verify checks signature, issuer, audience and time validity of the JWT
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Token, error) {
+	ctx, span := v.tracer.Start(ctx, "oidc.Verifier.Verify")
+	defer span.End()
+
+	parsed, err := jwt.ParseSigned(raw, SignatureAlgorithms)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if len(parsed.Headers) != 1 {
+		return nil, fmt.Errorf("%w: exactly one signature expected", ErrInvalidToken)
+	}
+
+	keys, err := v.keys.Keys(ctx, parsed.Headers[0].KeyID)
+
+	if errors.Is(err, ErrKeysUnavailable) {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claims := jwt.Claims{}
+	extra := make(map[string]interface{})
+
+	verified := false
+
+	for _, key := range keys {
+		if err := parsed.Claims(key.Key, &claims, &extra); err == nil {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+	}
+
+	expected := jwt.Expected{Issuer: v.issuer, Time: time.Now()}
+
+	if err := claims.ValidateWithLeeway(expected, leeway); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("%w: exp is required", ErrInvalidToken)
+	}
+
+	if len(v.audiences) > 0 && !intersects(claims.Audience, v.audiences) {
+		return nil, fmt.Errorf("%w: audience not allowed", ErrInvalidToken)
+	}
+
+	token := new(Token)
+	token.Subject = claims.Subject
+	token.Issuer = claims.Issuer
+	token.Audience = claims.Audience
+	token.ExpiresAt = claims.Expiry.Time()
+	token.Claims = extra
+	token.ClientID = stringClaim(extra, "client_id")
+
+	if token.ClientID == "" {
+		token.ClientID = stringClaim(extra, "azp")
+	}
+
+	token.Scopes = scopes(extra)
+
+	return token, nil
+}
+
+// UnverifiedIssuer reads the iss claim without checking the signature, to route a token to the
+// right verifier
+func UnverifiedIssuer(raw string) (string, error) {
+	parsed, err := jwt.ParseSigned(raw, SignatureAlgorithms)
+
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.Claims{}
+
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", err
+	}
+
+	return claims.Issuer, nil
+}
+
+// IsJWT tells if the token looks like a signed JWT rather than an opaque token
+func IsJWT(raw string) bool {
+	return strings.Count(raw, ".") == 2
+}
+
+func scopes(claims map[string]interface{}) []string {
+	switch v := claims["scope"].(type) {
+	case string:
+		return strings.Fields(v)
+	}
+
+	switch v := claims["scp"].(type) {
+	case []interface{}:
+		s := make([]string, 0, len(v))
+
+		for _, scope := range v {
+			if str, ok := scope.(string); ok {
+				s = append(s, str)
+			}
+		}
+
+		return s
+	case string:
+		return strings.Fields(v)
+	}
+
+	return nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	v, _ := claims[name].(string)
+
+	return v
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MarshalClaims returns the claims as JSON, used to hand them over to policies
+func (t *Token) MarshalClaims() ([]byte, error) {
+	return json.Marshal(t.Claims)
+}
+
+func NewVerifier(issuer string, audiences []string, keys *KeySet, tracer tracing.TracingInterface, logger logging.LoggerInterface) *Verifier {
+	v := new(Verifier)
+
+	v.issuer = issuer
+	v.audiences = audiences
+	v.keys = keys
+
+	v.tracer = tracer
+	v.logger = logger
+
+	return v
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const testIssuer = "https://hydra.example.com/"
+
+func newSignedToken(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.Claims, extra map[string]interface{}) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), kid),
+	)
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).Claims(extra).Serialize()
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return token
+}
+
+func newJWKSServer(key *ecdsa.PrivateKey, kid string) *httptest.Server {
+	keys := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"}}}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(keys)
+	}))
+}
+
+func newTestVerifier(url string, audiences []string) *Verifier {
+	logger := logging.NewNoopLogger()
+	tracer := tracing.NewNoopTracer()
+
+	return NewVerifier(testIssuer, audiences, NewKeySet(url, http.DefaultClient, tracer, logger), tracer, logger)
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv := newJWKSServer(key, "k1")
+	defer srv.Close()
+
+	raw := newSignedToken(t, key, "k1", jwt.Claims{
+		Issuer:   testIssuer,
+		Subject:  "alice",
+		Audience: jwt.Audience{"orders"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}, map[string]interface{}{"client_id": "app", "scp": []string{"orders:read"}})
+
+	token, err := newTestVerifier(srv.URL, []string{"orders"}).Verify(context.TODO(), raw)
+
+	assert.Nil(err)
+	assert.Equal("alice", token.Subject)
+	assert.Equal("app", token.ClientID)
+	assert.Equal([]string{"orders:read"}, token.Scopes)
+
+	issuer, err := UnverifiedIssuer(raw)
+
+	assert.Nil(err)
+	assert.Equal(testIssuer, issuer)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv := newJWKSServer(key, "k1")
+	defer srv.Close()
+
+	valid := jwt.Claims{Issuer: testIssuer, Subject: "alice", Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	tests := []struct {
+		name      string
+		token     string
+		audiences []string
+	}{
+		{"wrong key", newSignedToken(t, other, "k1", valid, nil), nil},
+		{"wrong issuer", newSignedToken(t, key, "k1", jwt.Claims{Issuer: "https://other/", Expiry: valid.Expiry}, nil), nil},
+		{"expired", newSignedToken(t, key, "k1", jwt.Claims{Issuer: testIssuer, Expiry: jwt.NewNumericDate(time.Now().Add(-time.Hour))}, nil), nil},
+		{"no expiry", newSignedToken(t, key, "k1", jwt.Claims{Issuer: testIssuer}, nil), nil},
+		{"wrong audience", newSignedToken(t, key, "k1", valid, nil), []string{"orders"}},
+		{"not a jwt", "opaque", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newTestVerifier(srv.URL, test.audiences).Verify(context.TODO(), test.token)
+
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("expected ErrInvalidToken got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+// Decision is the outcome of a rego query, the query can either return a boolean or an object
+// with the same fields as the JSON tags
+type Decision struct {
+	Allow   bool              `json:"allow"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// Evaluator runs rego queries against modules compiled once at startup, prepared queries are
+// cached per decision path
+type Evaluator struct {
+	compiler *ast.Compiler
+
+	queries map[string]*rego.PreparedEvalQuery
+	mu      sync.RWMutex
+
+	tracer  tracing.TracingInterface
+	monitor monitoring.MonitorInterface
+	logger  logging.LoggerInterface
+}
+
+// Evaluate queries the decision path, as slash or dot separated path under data, with the input
+// document; an undefined decision denies the request
+func (e *Evaluator) Evaluate(ctx context.Context, decision string, input interface{}) (*Decision, error) {
+	ctx, span := e.tracer.Start(ctx, "opa.Evaluator.Evaluate")
+	defer span.End()
+
+	start := time.Now()
+
+	d, err := e.evaluate(ctx, decision, input)
+
+	result := "error"
+
+	switch {
+	case err != nil:
+	case d.Allow:
+		result = "allow"
+	default:
+		result = "deny"
+	}
+
+	if m, merr := e.monitor.GetPolicyEvaluationMetric(map[string]string{"decision": decision, "result": result}); merr == nil {
+		m.Observe(time.Since(start).Seconds())
+	}
+
+	return d, err
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, decision string, input interface{}) (*Decision, error) {
+	query, err := e.query(ctx, decision)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := query.Eval(ctx, rego.EvalInput(input))
+
+	if err != nil {
+		return nil, fmt.Errorf("evaluation of %s failed: %w", decision, err)
+	}
+
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		e.logger.Debugf("decision %s undefined, denying", decision)
+		return new(Decision), nil
+	}
+
+	return parseDecision(rs[0].Expressions[0].Value)
+}
+
+// query returns the prepared query of the decision, preparing it on first use
+func (e *Evaluator) query(ctx context.Context, decision string) (*rego.PreparedEvalQuery, error) {
+	q := DecisionQuery(decision)
+
+	e.mu.RLock()
+	query, ok := e.queries[q]
+	e.mu.RUnlock()
+
+	if ok {
+		return query, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if query, ok := e.queries[q]; ok {
+		return query, nil
+	}
+
+	prepared, err := rego.New(
+		rego.Query(q),
+		rego.Compiler(e.compiler),
+	).PrepareForEval(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare decision %s: %w", decision, err)
+	}
+
+	e.queries[q] = &prepared
+
+	return &prepared, nil
+}
+
+// DecisionQuery turns a decision path like authz/allow into the data.authz.allow query
+func DecisionQuery(decision string) string {
+	path := strings.Trim(strings.ReplaceAll(decision, "/", "."), ".")
+
+	return "data." + path
+}
+
+func parseDecision(value interface{}) (*Decision, error) {
+	if allow, ok := value.(bool); ok {
+		return &Decision{Allow: allow}, nil
+	}
+
+	if _, ok := value.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("decision must be a boolean or an object, got %T", value)
+	}
+
+	raw, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(Decision)
+
+	if err := json.Unmarshal(raw, d); err != nil {
+		return nil, fmt.Errorf("invalid decision object: %w", err)
+	}
+
+	return d, nil
+}
+
+// LoadModules reads the rego modules from a file or from all the .rego files of a directory
+func LoadModules(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.rego")); err != nil {
+			return nil, err
+		}
+	}
+
+	modules := make(map[string]string, len(files))
+
+	for _, f := range files {
+		raw, err := os.ReadFile(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		modules[f] = string(raw)
+	}
+
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no rego modules found in %s", path)
+	}
+
+	return modules, nil
+}
+
+// NewEvaluator compiles the modules, compilation errors are returned straight away
+func NewEvaluator(modules map[string]string, tracer tracing.TracingInterface, monitor monitoring.MonitorInterface, logger logging.LoggerInterface) (*Evaluator, error) {
+	compiler, err := ast.CompileModules(modules)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile rego modules: %w", err)
+	}
+
+	e := new(Evaluator)
+
+	e.compiler = compiler
+	e.queries = make(map[string]*rego.PreparedEvalQuery)
+
+	e.tracer = tracer
+	e.monitor = monitor
+	e.logger = logger
+
+	return e, nil
+}
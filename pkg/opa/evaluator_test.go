@@ -0,0 +1,87 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package opa
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const module = `
+package authz
+
+default allow = false
+
+allow {
+	input.identity.subject == "alice"
+}
+
+response = {"allow": true, "headers": {"x-role": "admin"}} {
+	input.identity.claims.role == "admin"
+}
+
+response = {"allow": false, "status": 402, "body": "pay up"} {
+	input.identity.claims.role != "admin"
+}
+`
+
+func newTestEvaluator(t *testing.T) *Evaluator {
+	logger := logging.NewNoopLogger()
+
+	e, err := NewEvaluator(map[string]string{"authz.rego": module}, tracing.NewNoopTracer(), monitoring.NewNoopMonitor("test", logger), logger)
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return e
+}
+
+func TestEvaluate(t *testing.T) {
+	assert := assert.New(t)
+
+	e := newTestEvaluator(t)
+
+	input := func(subject, role string) map[string]interface{} {
+		return map[string]interface{}{"identity": map[string]interface{}{"subject": subject, "claims": map[string]interface{}{"role": role}}}
+	}
+
+	d, err := e.Evaluate(context.TODO(), "authz/allow", input("alice", ""))
+	assert.Nil(err)
+	assert.True(d.Allow)
+
+	d, err = e.Evaluate(context.TODO(), "authz.allow", input("bob", ""))
+	assert.Nil(err)
+	assert.False(d.Allow)
+
+	d, err = e.Evaluate(context.TODO(), "authz/response", input("bob", "admin"))
+	assert.Nil(err)
+	assert.Equal(&Decision{Allow: true, Headers: map[string]string{"x-role": "admin"}}, d)
+
+	d, err = e.Evaluate(context.TODO(), "authz/response", input("bob", "user"))
+	assert.Nil(err)
+	assert.Equal(&Decision{Status: 402, Body: "pay up"}, d)
+
+	// undefined decisions deny
+	d, err = e.Evaluate(context.TODO(), "authz/missing", input("alice", ""))
+	assert.Nil(err)
+	assert.False(d.Allow)
+
+	// authz/allow and authz.allow share the same prepared query
+	assert.Len(e.queries, 3)
+}
+
+func TestNewEvaluatorRejectsInvalidModules(t *testing.T) {
+	logger := logging.NewNoopLogger()
+
+	_, err := NewEvaluator(map[string]string{"broken.rego": "package authz\nallow {"}, tracing.NewNoopTracer(), monitoring.NewNoopMonitor("test", logger), logger)
+
+	assert.NotNil(t, err)
+}
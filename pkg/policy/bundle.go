@@ -0,0 +1,339 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+var (
+	// ErrInvalidSignature is returned when the bundle doesn't match its detached signature
+	ErrInvalidSignature = errors.New("invalid bundle signature")
+	// ErrRollback is returned when the bundle revision is older than the active one
+	ErrRollback = errors.New("policy bundle rollback")
+)
+
+// Poller keeps the store in sync with a signed policy bundle served over HTTP, bundles are
+// only activated once their detached signature is verified and a copy is kept on disk to be
+// used when the source is unreachable at startup
+type Poller struct {
+	url          string
+	signatureURL string
+	publicKey    crypto.PublicKey
+	cachePath    string
+
+	etag   string
+	loaded bool
+	// revision of the active bundle, older bundles are never activated
+	revision int64
+
+	client *http.Client
+	store  StoreInterface
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// Poll fetches the bundle, an unchanged bundle (304) is a no-op; when the first fetch fails the
+// last known good bundle is loaded from disk
+func (p *Poller) Poll(ctx context.Context) error {
+	ctx, span := p.tracer.Start(ctx, "policy.Poller.Poll")
+	defer span.End()
+
+	// the last known good bundle is the floor until a bundle is activated, a replica restarting
+	// never goes back to a bundle older than the one it last activated
+	if !p.loaded {
+		p.revision = p.lastKnownGoodRevision()
+	}
+
+	err := p.poll(ctx)
+
+	if err == nil || p.loaded {
+		return err
+	}
+
+	if lkgErr := p.loadLastKnownGood(); lkgErr != nil {
+		return fmt.Errorf("%w, last known good bundle unavailable: %v", err, lkgErr)
+	}
+
+	p.logger.Errorf("policy bundle fetch failed, using last known good bundle: %v", err)
+
+	return nil
+}
+
+func (p *Poller) poll(ctx context.Context) error {
+	bundle, etag, modified, err := p.fetch(ctx, p.url, p.etag)
+
+	if err != nil || !modified {
+		return err
+	}
+
+	signature, _, _, err := p.fetch(ctx, p.signatureURL, "")
+
+	if err != nil {
+		return fmt.Errorf("unable to fetch bundle signature: %w", err)
+	}
+
+	if err := p.activate(bundle, signature, fmt.Sprintf("%s@%s", p.url, etag)); err != nil {
+		return err
+	}
+
+	p.etag = etag
+	p.save(bundle, signature)
+
+	return nil
+}
+
+// activate verifies, parses and swaps in the bundle, bundles older than the active revision are
+// rejected so that a replayed bundle can't restore revoked policies
+func (p *Poller) activate(bundle, signature []byte, source string) error {
+	set, err := p.open(bundle, signature)
+
+	if err != nil {
+		return err
+	}
+
+	if set.Revision < p.revision {
+		return fmt.Errorf("%w: revision %d is older than the active revision %d", ErrRollback, set.Revision, p.revision)
+	}
+
+	b, err := p.store.Swap(set, source)
+
+	if err != nil {
+		return err
+	}
+
+	p.loaded = true
+	p.revision = set.Revision
+	p.logger.Infof("policy bundle %s (revision %d) activated from %s, %d policies", b.Version, set.Revision, source, len(set.Policies))
+
+	return nil
+}
+
+// open verifies and parses the bundle
+func (p *Poller) open(bundle, signature []byte) (*Set, error) {
+	if err := verifySignature(p.publicKey, bundle, signature); err != nil {
+		return nil, err
+	}
+
+	return Parse(bundle)
+}
+
+// fetch returns body and etag of the resource, modified is false on a 304
+func (p *Poller) fetch(ctx context.Context, url, etag string) ([]byte, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, false, nil
+	case http.StatusOK:
+	default:
+		return nil, "", false, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleBytes+1))
+
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if len(body) > maxBundleBytes {
+		return nil, "", false, fmt.Errorf("%s exceeds %d bytes", url, maxBundleBytes)
+	}
+
+	return body, resp.Header.Get("ETag"), true, nil
+}
+
+func (p *Poller) loadLastKnownGood() error {
+	bundle, signature, err := p.readLastKnownGood()
+
+	if err != nil {
+		return err
+	}
+
+	return p.activate(bundle, signature, "file://"+p.cachePath)
+}
+
+// lastKnownGoodRevision returns the revision of the bundle on disk, 0 without a valid one
+func (p *Poller) lastKnownGoodRevision() int64 {
+	bundle, signature, err := p.readLastKnownGood()
+
+	if err != nil {
+		return 0
+	}
+
+	set, err := p.open(bundle, signature)
+
+	if err != nil {
+		return 0
+	}
+
+	return set.Revision
+}
+
+func (p *Poller) readLastKnownGood() ([]byte, []byte, error) {
+	if p.cachePath == "" {
+		return nil, nil, fmt.Errorf("no cache path configured")
+	}
+
+	bundle, err := os.ReadFile(p.cachePath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signature, err := os.ReadFile(p.cachePath + ".sig")
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bundle, signature, nil
+}
+
+// save stores bundle and signature on disk, files are renamed in place so that a crash never
+// leaves a partial bundle behind
+func (p *Poller) save(bundle, signature []byte) {
+	if p.cachePath == "" {
+		return
+	}
+
+	for path, content := range map[string][]byte{p.cachePath: bundle, p.cachePath + ".sig": signature} {
+		if err := writeFileAtomic(path, content); err != nil {
+			p.logger.Errorf("unable to store last known good policy bundle: %v", err)
+			return
+		}
+	}
+}
+
+// Watch polls the bundle every interval until the context is cancelled
+func (p *Poller) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Poll(ctx); err != nil {
+				p.logger.Errorf("policy bundle poll failed, keeping current policies: %v", err)
+			}
+		}
+	}
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// verifySignature checks the detached base64 signature of the bundle, Ed25519 signs the bundle
+// itself while ECDSA (ASN.1, as produced by cosign sign-blob) and RSA PKCS#1 v1.5 sign its SHA-256
+func verifySignature(key crypto.PublicKey, bundle, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	digest := sha256.Sum256(bundle)
+	valid := false
+
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, bundle, sig)
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// ParsePublicKey reads a PEM encoded PKIX public key
+func ParsePublicKey(raw []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func NewPoller(url, signatureURL string, publicKey crypto.PublicKey, cachePath string, client *http.Client, store StoreInterface, tracer tracing.TracingInterface, logger logging.LoggerInterface) *Poller {
+	p := new(Poller)
+
+	p.url = url
+	p.signatureURL = signatureURL
+	p.publicKey = publicKey
+	p.cachePath = cachePath
+
+	p.client = client
+	p.store = store
+
+	p.tracer = tracer
+	p.logger = logger
+
+	return p
+}
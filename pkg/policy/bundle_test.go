@@ -0,0 +1,169 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+type bundleServer struct {
+	bundle    []byte
+	signature []byte
+	etag      string
+	down      bool
+
+	bundleRequests int
+}
+
+func (s *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/bundle.yaml":
+		s.bundleRequests++
+
+		if r.Header.Get("If-None-Match") == s.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", s.etag)
+		_, _ = w.Write(s.bundle)
+	case "/bundle.yaml.sig":
+		_, _ = w.Write(s.signature)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *bundleServer) publish(key ed25519.PrivateKey, bundle, etag string) {
+	s.bundle = []byte(bundle)
+	s.signature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, s.bundle)))
+	s.etag = etag
+}
+
+func newTestPoller(url string, key ed25519.PublicKey, cachePath string, store *Store) *Poller {
+	return NewPoller(
+		url+"/bundle.yaml", url+"/bundle.yaml.sig", key, cachePath, http.DefaultClient, store,
+		tracing.NewNoopTracer(), logging.NewNoopLogger(),
+	)
+}
+
+func TestPollActivatesSignedBundle(t *testing.T) {
+	assert := assert.New(t)
+
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+
+	s := new(bundleServer)
+	s.publish(private, "policies: [{name: api}]", `"v1"`)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	store := NewStore(new(Set), "")
+	p := newTestPoller(srv.URL, public, filepath.Join(t.TempDir(), "bundle.yaml"), store)
+
+	assert.Nil(p.Poll(context.TODO()))
+	assert.Equal("api", store.Policies().Policies[0].Name)
+	assert.Equal(srv.URL+`/bundle.yaml@"v1"`, store.Current().Source)
+
+	version := store.Current().Version
+
+	// unchanged bundle, the store is left alone
+	assert.Nil(p.Poll(context.TODO()))
+	assert.Equal(version, store.Current().Version)
+	assert.Equal(2, s.bundleRequests)
+
+	// tampered bundle, the signature doesn't match anymore
+	s.bundle = []byte("policies: [{name: admin}]")
+	s.etag = `"v2"`
+
+	assert.True(errors.Is(p.Poll(context.TODO()), ErrInvalidSignature))
+	assert.Equal(version, store.Current().Version)
+
+	s.publish(private, "policies: [{name: admin}]", `"v3"`)
+
+	assert.Nil(p.Poll(context.TODO()))
+	assert.Equal("admin", store.Policies().Policies[0].Name)
+}
+
+func TestPollFallsBackToLastKnownGood(t *testing.T) {
+	assert := assert.New(t)
+
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	cachePath := filepath.Join(t.TempDir(), "bundle.yaml")
+
+	s := new(bundleServer)
+	s.publish(private, "policies: [{name: api}]", `"v1"`)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	assert.Nil(newTestPoller(srv.URL, public, cachePath, NewStore(new(Set), "")).Poll(context.TODO()))
+
+	// a new replica starting while the bundle server is down
+	s.down = true
+
+	store := NewStore(new(Set), "")
+
+	assert.Nil(newTestPoller(srv.URL, public, cachePath, store).Poll(context.TODO()))
+	assert.Equal("api", store.Policies().Policies[0].Name)
+	assert.Equal("file://"+cachePath, store.Current().Source)
+
+	// no last known good bundle either
+	assert.NotNil(newTestPoller(srv.URL, public, filepath.Join(t.TempDir(), "missing.yaml"), NewStore(new(Set), "")).Poll(context.TODO()))
+}
+
+func TestPollRejectsRollback(t *testing.T) {
+	assert := assert.New(t)
+
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	cachePath := filepath.Join(t.TempDir(), "bundle.yaml")
+
+	s := new(bundleServer)
+	s.publish(private, "revision: 2\npolicies: [{name: api}]", `"v2"`)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	store := NewStore(new(Set), "")
+	p := newTestPoller(srv.URL, public, cachePath, store)
+
+	assert.Nil(p.Poll(context.TODO()))
+
+	// an older, validly signed, bundle replayed by the source
+	s.publish(private, "revision: 1\npolicies: [{name: admin}]", `"v1"`)
+
+	assert.ErrorIs(p.Poll(context.TODO()), ErrRollback)
+	assert.Equal("api", store.Policies().Policies[0].Name)
+
+	// a restarting replica keeps the revision of its last known good bundle as floor
+	store = NewStore(new(Set), "")
+	p = newTestPoller(srv.URL, public, cachePath, store)
+
+	assert.Nil(p.Poll(context.TODO()))
+	assert.Equal("api", store.Policies().Policies[0].Name)
+	assert.Equal("file://"+cachePath, store.Current().Source)
+
+	s.publish(private, "revision: 3\npolicies: [{name: admin}]", `"v3"`)
+
+	assert.Nil(p.Poll(context.TODO()))
+	assert.Equal("admin", store.Policies().Policies[0].Name)
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Condition is a check on the request body, JSONField is a dot separated path into the JSON
+// document, the condition holds when the field is one of Values, or just present if no values
+// are listed
+type Condition struct {
+	JSONField string   `json:"json_field" yaml:"json_field"`
+	Values    []string `json:"values,omitempty" yaml:"values"`
+}
+
+// Matches evaluates the condition against a decoded JSON document
+func (c *Condition) Matches(document interface{}) bool {
+	value, found := lookup(document, strings.Split(c.JSONField, "."))
+
+	if !found {
+		return false
+	}
+
+	if len(c.Values) == 0 {
+		return true
+	}
+
+	s, ok := scalar(value)
+
+	if !ok {
+		return false
+	}
+
+	for _, v := range c.Values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchesBody evaluates every condition of the policy against the request body, it fails when
+// the body is not a JSON document
+func (p *Policy) MatchesBody(body []byte) (bool, error) {
+	if len(p.Conditions) == 0 {
+		return true, nil
+	}
+
+	var document interface{}
+
+	if err := json.Unmarshal(body, &document); err != nil {
+		return false, fmt.Errorf("body is not valid JSON: %w", err)
+	}
+
+	for _, c := range p.Conditions {
+		if !c.Matches(document) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func lookup(document interface{}, path []string) (interface{}, bool) {
+	current := document
+
+	for _, key := range path {
+		object, ok := current.(map[string]interface{})
+
+		if !ok {
+			return nil, false
+		}
+
+		if current, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// scalar renders strings, numbers and booleans the way they are written in the policy
+func scalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool, float64:
+		return fmt.Sprint(v), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesBody(t *testing.T) {
+	p := &Policy{
+		Name:     "orders",
+		ReadBody: true,
+		Conditions: []Condition{
+			{JSONField: "order.action", Values: []string{"read", "list"}},
+			{JSONField: "dry_run", Values: []string{"true"}},
+			{JSONField: "tenant"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected bool
+		err      bool
+	}{
+		{"all conditions hold", `{"order": {"action": "list"}, "dry_run": true, "tenant": 1}`, true, false},
+		{"value not allowed", `{"order": {"action": "delete"}, "dry_run": true, "tenant": 1}`, false, false},
+		{"field missing", `{"order": {"action": "read"}, "dry_run": true}`, false, false},
+		{"object instead of scalar", `{"order": {"action": {"read": 1}}, "dry_run": true, "tenant": 1}`, false, false},
+		{"not json", `action=read`, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := p.MatchesBody([]byte(test.body))
+
+			assert.Equal(t, test.expected, matches)
+			assert.Equal(t, test.err, err != nil)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"text/template"
+)
+
+const (
+	FormatJSON = "json"
+	FormatHTML = "html"
+	FormatText = "text"
+)
+
+// DenyResponse customises the response sent to clients on denied requests
+type DenyResponse struct {
+	// Status replaces the 403 of denied requests, other denials keep their status
+	Status  int               `json:"status,omitempty" yaml:"status"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	Body    DenyBody          `json:"body,omitempty" yaml:"body"`
+}
+
+// DenyBody holds the body templates for each response format, the built in body is used for
+// missing templates
+type DenyBody struct {
+	JSON string `json:"json,omitempty" yaml:"json"`
+	HTML string `json:"html,omitempty" yaml:"html"`
+	Text string `json:"text,omitempty" yaml:"text"`
+}
+
+// Denial is the data available to deny body templates, it never carries internal errors
+type Denial struct {
+	Status    int    `json:"status"`
+	Reason    string `json:"reason"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Policy    string `json:"policy,omitempty"`
+}
+
+var (
+	funcs = template.FuncMap{"json": toJSON}
+
+	defaultHTML = htmltemplate.Must(htmltemplate.New("html").Parse(
+		`<!DOCTYPE html><html><head><title>{{ .Status }} {{ .Title }}</title></head>` +
+			`<body><h1>{{ .Title }}</h1>{{ if .Detail }}<p>{{ .Detail }}</p>{{ end }}` +
+			`<p>Reason: {{ .Reason }}</p>{{ if .RequestID }}<p>Request ID: {{ .RequestID }}</p>{{ end }}</body></html>`,
+	))
+	defaultText = template.Must(template.New("text").Parse(
+		"{{ .Status }} {{ .Title }}\n{{ if .Detail }}{{ .Detail }}\n{{ end }}reason: {{ .Reason }}\n{{ if .RequestID }}request id: {{ .RequestID }}\n{{ end }}",
+	))
+)
+
+// Render writes the body of the denial in the given format, custom templates of the policy are
+// preferred to the built in ones
+func (d *DenyResponse) Render(format string, denial Denial) ([]byte, error) {
+	var body DenyBody
+
+	if d != nil {
+		body = d.Body
+	}
+
+	buf := new(bytes.Buffer)
+
+	switch format {
+	case FormatHTML:
+		t := defaultHTML
+
+		if body.HTML != "" {
+			custom, err := htmltemplate.New("html").Funcs(htmltemplate.FuncMap(funcs)).Parse(body.HTML)
+
+			if err != nil {
+				return nil, err
+			}
+
+			t = custom
+		}
+
+		err := t.Execute(buf, denial)
+
+		return buf.Bytes(), err
+	case FormatText:
+		return render(buf, defaultText, body.Text, denial)
+	default:
+		if body.JSON == "" {
+			return problem(denial)
+		}
+
+		return render(buf, nil, body.JSON, denial)
+	}
+}
+
+func (d *DenyResponse) validate() error {
+	if d.Status != 0 && (d.Status < http.StatusBadRequest || d.Status > 599) {
+		return fmt.Errorf("deny status %d is not an error status", d.Status)
+	}
+
+	if _, err := template.New("json").Funcs(funcs).Parse(d.Body.JSON); err != nil {
+		return fmt.Errorf("deny json template: %w", err)
+	}
+
+	if _, err := htmltemplate.New("html").Funcs(htmltemplate.FuncMap(funcs)).Parse(d.Body.HTML); err != nil {
+		return fmt.Errorf("deny html template: %w", err)
+	}
+
+	if _, err := template.New("text").Funcs(funcs).Parse(d.Body.Text); err != nil {
+		return fmt.Errorf("deny text template: %w", err)
+	}
+
+	return nil
+}
+
+func render(buf *bytes.Buffer, fallback *template.Template, custom string, denial Denial) ([]byte, error) {
+	t := fallback
+
+	if custom != "" {
+		var err error
+
+		if t, err = template.New("custom").Funcs(funcs).Parse(custom); err != nil {
+			return nil, err
+		}
+	}
+
+	err := t.Execute(buf, denial)
+
+	return buf.Bytes(), err
+}
+
+// problem is the RFC 7807 problem details document of the denial, the reason and request id
+// are extension members
+func problem(denial Denial) ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Type string `json:"type"`
+			Denial
+		}{Type: "about:blank", Denial: denial},
+	)
+}
+
+// toJSON lets JSON templates quote values, as in {"id": {{ json .RequestID }}}
+func toJSON(v interface{}) (string, error) {
+	raw, err := json.Marshal(v)
+
+	return string(raw), err
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import "strings"
+
+// Step is the evaluation of a single policy against a request
+type Step struct {
+	Policy  string `json:"policy"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// Explanation tells which policy a request gets and why the ones before it were skipped
+type Explanation struct {
+	Matched        *Policy   `json:"matched"`
+	Authenticators []string  `json:"authenticators"`
+	Mode           ChainMode `json:"mode"`
+	Trace          []Step    `json:"trace"`
+}
+
+// Explain evaluates the request attributes like Find does, recording every step
+func (s *Set) Explain(host, path, method string) *Explanation {
+	e := new(Explanation)
+	e.Trace = make([]Step, 0)
+
+	for i := range s.Policies {
+		p := &s.Policies[i]
+		reason := p.Match.mismatch(host, path, method)
+
+		e.Trace = append(e.Trace, Step{Policy: p.Name, Matched: reason == "", Reason: reason})
+
+		if reason == "" {
+			e.Matched = p
+			break
+		}
+	}
+
+	if n := len(e.Trace); n > 0 && e.Trace[n-1].Matched {
+		e.Trace[n-1].Reason = "matched"
+	}
+
+	e.Authenticators = e.Matched.Chain()
+	e.Mode = e.Matched.EvaluationMode()
+
+	return e
+}
+
+// mismatch returns why the request doesn't match, empty if it does
+func (m *Match) mismatch(host, path, method string) string {
+	switch {
+	case !m.MatchesHost(host):
+		return "host not in " + strings.Join(m.Hosts, ", ")
+	case len(m.Methods) > 0 && !containsFold(m.Methods, method):
+		return "method not in " + strings.Join(m.Methods, ", ")
+	case !m.MatchesPath(path):
+		return "path without prefix " + m.PathPrefix
+	}
+
+	return ""
+}
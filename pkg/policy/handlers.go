@@ -0,0 +1,224 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const (
+	maxBundleBytes = 1 << 20
+	adminSource    = "admin-api"
+)
+
+// BundleInfo describes the active bundle without its policies
+type BundleInfo struct {
+	Version  string    `json:"version"`
+	Source   string    `json:"source"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// BundleResponse is the active bundle as returned by the admin API
+type BundleResponse struct {
+	BundleInfo
+	Policies []Policy `json:"policies"`
+}
+
+// ExplainRequest describes the hypothetical request to evaluate
+type ExplainRequest struct {
+	Host   string `json:"host"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+}
+
+type API struct {
+	// secret protects the bundle upload, uploads are disabled when empty
+	secret string
+
+	store StoreInterface
+	// tenants are the read only policy stores of the tenants by name
+	tenants map[string]StoreInterface
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+func (a *API) RegisterEndpoints(mux *chi.Mux) {
+	mux.Get("/api/v0/policies", a.list)
+	mux.Get("/api/v0/policies/{name}", a.get)
+	mux.Post("/api/v0/policies/explain", a.explain)
+
+	if len(a.tenants) > 0 {
+		mux.Get("/api/v0/tenants/{tenant}/policies", a.list)
+		mux.Get("/api/v0/tenants/{tenant}/policies/{name}", a.get)
+		mux.Post("/api/v0/tenants/{tenant}/policies/explain", a.explain)
+	}
+
+	if a.secret == "" {
+		a.logger.Warn("policy admin secret not set, policy upload disabled")
+		return
+	}
+
+	mux.Put("/api/v0/policies", a.push)
+}
+
+// storeOf returns the store of the tenant of the route, the default store on the routes without
+// tenant, false after answering 404 for unknown tenants
+func (a *API) storeOf(w http.ResponseWriter, r *http.Request) (StoreInterface, bool) {
+	name := chi.URLParam(r, "tenant")
+
+	if name == "" {
+		return a.store, true
+	}
+
+	store, ok := a.tenants[name]
+
+	if !ok {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return store, true
+}
+
+func (a *API) list(w http.ResponseWriter, r *http.Request) {
+	store, ok := a.storeOf(w, r)
+
+	if !ok {
+		return
+	}
+
+	b := store.Current()
+
+	writeJSON(w, http.StatusOK, BundleResponse{BundleInfo: info(b), Policies: b.Set.Policies})
+}
+
+func (a *API) get(w http.ResponseWriter, r *http.Request) {
+	store, ok := a.storeOf(w, r)
+
+	if !ok {
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+
+	for _, p := range store.Current().Set.Policies {
+		if p.Name == name {
+			writeJSON(w, http.StatusOK, p)
+			return
+		}
+	}
+
+	http.Error(w, "policy not found", http.StatusNotFound)
+}
+
+func (a *API) explain(w http.ResponseWriter, r *http.Request) {
+	store, ok := a.storeOf(w, r)
+
+	if !ok {
+		return
+	}
+
+	req := new(ExplainRequest)
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleBytes)).Decode(req); err != nil {
+		http.Error(w, "invalid explain payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" {
+		req.Path = "/"
+	}
+
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	b := store.Current()
+
+	writeJSON(w, http.StatusOK, struct {
+		BundleInfo
+		*Explanation
+	}{info(b), b.Set.Explain(req.Host, req.Path, req.Method)})
+}
+
+// push validates the uploaded bundle, YAML or JSON, and activates it
+func (a *API) push(w http.ResponseWriter, r *http.Request) {
+	if !a.authenticated(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	_, span := a.tracer.Start(r.Context(), "policy.API.push")
+	defer span.End()
+
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleBytes))
+
+	if err != nil {
+		http.Error(w, "unable to read bundle", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	set, err := Parse(raw)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b, err := a.store.Swap(set, adminSource)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.logger.Infof("policy bundle %s activated from the admin API, %d policies", b.Version, len(set.Policies))
+
+	writeJSON(w, http.StatusOK, info(b))
+}
+
+func (a *API) authenticated(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !found {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(a.secret)) == 1
+}
+
+func info(b *Bundle) BundleInfo {
+	return BundleInfo{Version: b.Version, Source: b.Source, LoadedAt: b.LoadedAt}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func NewAPI(secret string, store StoreInterface, tenants map[string]StoreInterface, tracer tracing.TracingInterface, logger logging.LoggerInterface) *API {
+	a := new(API)
+
+	a.secret = secret
+	a.store = store
+	a.tenants = tenants
+
+	a.tracer = tracer
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+func newAdminMux(store *Store) *chi.Mux {
+	mux := chi.NewMux()
+	NewAPI("secret", store, nil, tracing.NewNoopTracer(), logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+	return mux
+}
+
+func TestExplain(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore(&Set{
+		Policies: []Policy{
+			{Name: "admin", Match: Match{PathPrefix: "/admin", Methods: []string{"POST"}}},
+			{Name: "api", Match: Match{Hosts: []string{"api.example.com"}}, Authenticators: []string{AuthenticatorJWT}},
+		},
+	}, "policies.yaml")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v0/policies/explain", strings.NewReader(`{"host": "api.example.com", "path": "/admin/users"}`))
+	newAdminMux(store).ServeHTTP(w, r)
+
+	assert.Equal(http.StatusOK, w.Code)
+
+	e := struct {
+		BundleInfo
+		Explanation
+	}{}
+
+	assert.Nil(json.NewDecoder(w.Body).Decode(&e))
+	assert.Equal(store.Current().Version, e.Version)
+	assert.Equal("api", e.Matched.Name)
+	assert.Equal([]string{AuthenticatorJWT}, e.Authenticators)
+	assert.Equal([]Step{{Policy: "admin", Reason: "method not in POST"}, {Policy: "api", Matched: true, Reason: "matched"}}, e.Trace)
+}
+
+func TestPushValidatesBeforeSwap(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore(&Set{Policies: []Policy{{Name: "api"}}}, "policies.yaml")
+	version := store.Current().Version
+	mux := newAdminMux(store)
+
+	push := func(token, body string) int {
+		r := httptest.NewRequest(http.MethodPut, "/api/v0/policies", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	assert.Equal(http.StatusUnauthorized, push("wrong", "policies: [{name: other}]"))
+	assert.Equal(http.StatusBadRequest, push("secret", "policies: [{name: api}, {name: api}]"))
+	assert.Equal(version, store.Current().Version)
+
+	assert.Equal(http.StatusOK, push("secret", "policies: [{name: other}]"))
+	assert.NotEqual(version, store.Current().Version)
+	assert.Equal(adminSource, store.Current().Source)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/policies/other", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/policies/api", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestTenantPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore(&Set{Policies: []Policy{{Name: "api"}}}, "policies.yaml")
+	acme := NewStore(&Set{Policies: []Policy{{Name: "ui", Authenticators: []string{AuthenticatorKratosCookie}}}}, "acme.yaml")
+
+	mux := chi.NewMux()
+	NewAPI("secret", store, map[string]StoreInterface{"acme": acme}, tracing.NewNoopTracer(), logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/tenants/acme/policies", nil))
+
+	b := new(BundleResponse)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Nil(json.NewDecoder(w.Body).Decode(b))
+	assert.Equal("acme.yaml", b.Source)
+	assert.Equal("ui", b.Policies[0].Name)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/tenants/acme/policies/api", nil))
+
+	assert.Equal(http.StatusNotFound, w.Code, "policies of the default backends are not listed for tenants")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v0/tenants/globex/policies/explain", strings.NewReader(`{}`)))
+
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v0/tenants/acme/policies", strings.NewReader("policies: []")))
+
+	assert.Equal(http.StatusMethodNotAllowed, w.Code, "tenant policies are read only")
+}
@@ -0,0 +1,9 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+type StoreInterface interface {
+	Current() *Bundle
+	Swap(*Set, string) (*Bundle, error)
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// Load reads a policy set from a YAML (or JSON) file, an empty path returns an empty set
+func Load(path string) (*Set, error) {
+	if path == "" {
+		return new(Set), nil
+	}
+
+	raw, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read policies: %w", err)
+	}
+
+	return Parse(raw)
+}
+
+// Parse decodes and validates a YAML (or JSON) policy set
+func Parse(raw []byte) (*Set, error) {
+	s := new(Set)
+
+	if err := yaml.Unmarshal(raw, s); err != nil {
+		return nil, fmt.Errorf("unable to parse policies: %w", err)
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Validate checks the policy set for inconsistencies
+func (s *Set) Validate() error {
+	names := make(map[string]bool)
+
+	for _, p := range s.Policies {
+		if p.Name == "" {
+			return fmt.Errorf("policy name is required")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("duplicate policy %s", p.Name)
+		}
+
+		names[p.Name] = true
+
+		for _, rl := range p.RateLimits {
+			switch rl.Key {
+			case RateLimitIdentity, RateLimitClientID, RateLimitIP, RateLimitRoute:
+			default:
+				return fmt.Errorf("policy %s: unknown rate limit key %q", p.Name, rl.Key)
+			}
+
+			if rl.Requests <= 0 || rl.Period <= 0 {
+				return fmt.Errorf("policy %s: rate limit needs positive requests and period", p.Name)
+			}
+		}
+
+		switch p.Mode {
+		case "", ChainFirst, ChainAll:
+		default:
+			return fmt.Errorf("policy %s: unknown chain mode %q", p.Name, p.Mode)
+		}
+
+		if len(p.Conditions) > 0 && !p.ReadBody {
+			return fmt.Errorf("policy %s: body conditions need read_body", p.Name)
+		}
+
+		for _, c := range p.Conditions {
+			if c.JSONField == "" {
+				return fmt.Errorf("policy %s: condition without json_field", p.Name)
+			}
+		}
+
+		if p.Rego != nil && p.Rego.Decision == "" {
+			return fmt.Errorf("policy %s: rego decision is required", p.Name)
+		}
+
+		for _, sa := range p.ServiceAccounts {
+			if !strings.HasPrefix(sa, serviceAccountPrefix) {
+				return fmt.Errorf("policy %s: service account %q must start with %s", p.Name, sa, serviceAccountPrefix)
+			}
+		}
+
+		if p.Deny != nil {
+			if err := p.Deny.validate(); err != nil {
+				return fmt.Errorf("policy %s: %w", p.Name, err)
+			}
+		}
+
+		for _, name := range p.Authenticators {
+			if !contains(Authenticators, name) {
+				return fmt.Errorf("policy %s: unknown authenticator %q", p.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// Bundle is a policy set as activated in the store
+type Bundle struct {
+	Set *Set `json:"set"`
+	// Version is derived from the content, two bundles with the same policies share it
+	Version  string    `json:"version"`
+	Source   string    `json:"source"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// Store holds the active policy bundle, swapped atomically so that a check always sees a
+// consistent policy set
+type Store struct {
+	current atomic.Pointer[Bundle]
+}
+
+func (s *Store) Current() *Bundle {
+	return s.current.Load()
+}
+
+// Policies returns the active policy set
+func (s *Store) Policies() *Set {
+	return s.current.Load().Set
+}
+
+// Swap validates the set and makes it the active one, the active set is left untouched on error
+func (s *Store) Swap(set *Set, source string) (*Bundle, error) {
+	if err := set.Validate(); err != nil {
+		return nil, err
+	}
+
+	b := new(Bundle)
+	b.Set = set
+	b.Version = Version(set)
+	b.Source = source
+	b.LoadedAt = time.Now()
+
+	s.current.Store(b)
+
+	return b, nil
+}
+
+// Version returns a short content hash of the set
+func Version(set *Set) string {
+	raw, _ := json.Marshal(set)
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewStore returns a store with the set active, the set is expected to be validated already
+func NewStore(set *Set, source string) *Store {
+	s := new(Store)
+
+	b := new(Bundle)
+	b.Set = set
+	b.Version = Version(set)
+	b.Source = source
+	b.LoadedAt = time.Now()
+
+	s.current.Store(b)
+
+	return s
+}
@@ -0,0 +1,256 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// RateLimitKey identifies what a rate limit bucket is keyed on
+type RateLimitKey string
+
+const (
+	RateLimitIdentity RateLimitKey = "identity"
+	RateLimitClientID RateLimitKey = "client_id"
+	RateLimitIP       RateLimitKey = "ip"
+	RateLimitRoute    RateLimitKey = "route"
+)
+
+// ChainMode tells how the authenticator chain of a policy is evaluated
+type ChainMode string
+
+const (
+	// ChainFirst stops at the first authenticator finding valid credentials
+	ChainFirst ChainMode = "first"
+	// ChainAll requires every authenticator of the chain to succeed
+	ChainAll ChainMode = "all"
+)
+
+const (
+	AuthenticatorIntrospection      = "oauth2_introspection"
+	AuthenticatorJWT                = "jwt"
+	AuthenticatorKratosCookie       = "kratos_cookie"
+	AuthenticatorKratosSessionToken = "kratos_session_token"
+	AuthenticatorAPIKey             = "api_key"
+	AuthenticatorClientCertificate  = "client_certificate"
+	AuthenticatorRelyingParty       = "relying_party"
+	AuthenticatorAnonymous          = "anonymous"
+	AuthenticatorServiceAccount     = "kubernetes_service_account"
+)
+
+// Authenticators lists every authenticator name a policy can refer to
+var Authenticators = []string{
+	AuthenticatorIntrospection,
+	AuthenticatorJWT,
+	AuthenticatorKratosCookie,
+	AuthenticatorKratosSessionToken,
+	AuthenticatorAPIKey,
+	AuthenticatorClientCertificate,
+	AuthenticatorRelyingParty,
+	AuthenticatorAnonymous,
+	AuthenticatorServiceAccount,
+}
+
+// Set is the ordered list of policies, first match wins
+type Set struct {
+	// Revision orders the bundles of a source, bundles older than the active one are rejected
+	Revision int64    `json:"revision,omitempty" yaml:"revision"`
+	Policies []Policy `json:"policies" yaml:"policies"`
+}
+
+// Policy describes how requests for a route are treated
+type Policy struct {
+	Name       string      `json:"name" yaml:"name"`
+	Match      Match       `json:"match" yaml:"match"`
+	RateLimits []RateLimit `json:"rate_limits,omitempty" yaml:"rate_limits"`
+	// RelyingParty authenticates browsers through the authorization code flow instead of kratos
+	RelyingParty bool `json:"relying_party,omitempty" yaml:"relying_party"`
+	// TokenExchange downscopes accepted bearer tokens before they are sent upstream
+	TokenExchange *TokenExchange `json:"token_exchange,omitempty" yaml:"token_exchange"`
+	// RequireDPoP rejects access tokens not presented with the DPoP scheme and a valid proof
+	RequireDPoP bool `json:"require_dpop,omitempty" yaml:"require_dpop"`
+	// Issuers restricts the issuers of the JWT access tokens accepted on the route, any trusted
+	// issuer when empty
+	Issuers []string `json:"issuers,omitempty" yaml:"issuers"`
+	// Workloads lists the client certificate SAN URIs or subjects allowed on the route,
+	// a trailing * matches any suffix
+	Workloads []string `json:"workloads,omitempty" yaml:"workloads"`
+	// ServiceAccounts lists the kubernetes service accounts allowed on the route, as
+	// system:serviceaccount:<namespace>:<name>, a trailing * matches any suffix
+	ServiceAccounts []string `json:"service_accounts,omitempty" yaml:"service_accounts"`
+	// APIKey enables static API key authentication on the route
+	APIKey *APIKeySource `json:"api_key,omitempty" yaml:"api_key"`
+	// Authenticators is the ordered chain of authenticators tried on the route, when empty the
+	// default chain is used
+	Authenticators []string `json:"authenticators,omitempty" yaml:"authenticators"`
+	// Mode is either first (default) or all
+	Mode ChainMode `json:"mode,omitempty" yaml:"mode"`
+	// ReadBody makes the authorizer read the request body forwarded by envoy, bodies are
+	// ignored otherwise
+	ReadBody bool `json:"read_body,omitempty" yaml:"read_body"`
+	// Conditions must all hold on the request body for the request to be allowed
+	Conditions []Condition `json:"conditions,omitempty" yaml:"conditions"`
+	// Rego delegates the decision on authenticated requests to an OPA query
+	Rego *Rego `json:"rego,omitempty" yaml:"rego"`
+	// Scopes must all be granted to the identity, tokens missing any are denied with a 403
+	// insufficient_scope challenge
+	Scopes []string `json:"scopes,omitempty" yaml:"scopes"`
+	// Deny customises the status, headers and body of denied requests
+	Deny *DenyResponse `json:"deny,omitempty" yaml:"deny"`
+}
+
+// Rego points to the decision queried on the embedded OPA evaluator
+type Rego struct {
+	// Decision is the slash or dot separated path of the rule under data
+	Decision string `json:"decision" yaml:"decision"`
+}
+
+// APIKeySource tells where the API key is read from, the header is tried first
+type APIKeySource struct {
+	Header string `json:"header,omitempty" yaml:"header"`
+	Query  string `json:"query,omitempty" yaml:"query"`
+}
+
+// TokenExchange describes the audience and scopes of the token forwarded to the upstream
+type TokenExchange struct {
+	Audience string   `json:"audience" yaml:"audience"`
+	Scopes   []string `json:"scopes,omitempty" yaml:"scopes"`
+}
+
+// Match selects the requests a policy applies to, empty fields match everything
+type Match struct {
+	Hosts      []string `json:"hosts,omitempty" yaml:"hosts"`
+	PathPrefix string   `json:"path_prefix,omitempty" yaml:"path_prefix"`
+	Methods    []string `json:"methods,omitempty" yaml:"methods"`
+}
+
+// RateLimit is a token bucket refilled with Requests tokens every Period, holding at most Burst tokens
+type RateLimit struct {
+	Key      RateLimitKey  `json:"key" yaml:"key"`
+	Requests int           `json:"requests" yaml:"requests"`
+	Period   time.Duration `json:"period" yaml:"period"`
+	Burst    int           `json:"burst,omitempty" yaml:"burst"`
+}
+
+// Find returns the first policy matching the request attributes, nil if none does
+func (s *Set) Find(host, path, method string) *Policy {
+	if s == nil {
+		return nil
+	}
+
+	for i := range s.Policies {
+		if s.Policies[i].Match.Matches(host, path, method) {
+			return &s.Policies[i]
+		}
+	}
+
+	return nil
+}
+
+func (m *Match) Matches(host, path, method string) bool {
+	return m.mismatch(host, path, method) == ""
+}
+
+// MatchesHost tells if the policy applies to the host, ports are ignored
+func (m *Match) MatchesHost(host string) bool {
+	return len(m.Hosts) == 0 || containsFold(m.Hosts, stripPort(host))
+}
+
+// MatchesPath tells if the path is under the prefix, the path is cleaned first and the prefix
+// matches whole segments only, /api covers /api/v1 but not /apix
+func (m *Match) MatchesPath(p string) bool {
+	prefix := strings.TrimSuffix(m.PathPrefix, "/")
+
+	if prefix == "" {
+		return true
+	}
+
+	cleaned := path.Clean("/" + p)
+
+	return cleaned == prefix || strings.HasPrefix(cleaned, prefix+"/")
+}
+
+// Chain returns the authenticators of the policy, falling back to the default chain: API key,
+// access token, client certificate and then the browser session, either the relying party
+// one or the kratos cookie; jwt, kratos_session_token and kubernetes_service_account are
+// never part of the default chain and have to be listed by the policy
+func (p *Policy) Chain() []string {
+	if p != nil && len(p.Authenticators) > 0 {
+		return p.Authenticators
+	}
+
+	chain := []string{AuthenticatorAPIKey, AuthenticatorIntrospection, AuthenticatorClientCertificate}
+
+	if p != nil && p.RelyingParty {
+		return append(chain, AuthenticatorRelyingParty)
+	}
+
+	return append(chain, AuthenticatorKratosCookie)
+}
+
+// EvaluationMode returns the evaluation mode of the chain, defaults to first
+func (p *Policy) EvaluationMode() ChainMode {
+	if p == nil || p.Mode == "" {
+		return ChainFirst
+	}
+
+	return p.Mode
+}
+
+// AllowsWorkload checks the client certificate identities against the policy workloads
+func (p *Policy) AllowsWorkload(uris []string, subject string) bool {
+	for _, pattern := range p.Workloads {
+		for _, id := range append([]string{subject}, uris...) {
+			if id != "" && matchPattern(pattern, id) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AllowsServiceAccount checks the service account username against the policy, every service
+// account is allowed when the policy lists none
+func (p *Policy) AllowsServiceAccount(username string) bool {
+	if p == nil || len(p.ServiceAccounts) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.ServiceAccounts {
+		if matchPattern(pattern, username) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPattern(pattern, value string) bool {
+	if prefix, found := strings.CutSuffix(pattern, "*"); found {
+		return strings.HasPrefix(value, prefix)
+	}
+
+	return pattern == value
+}
+
+func containsFold(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stripPort(host string) string {
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		return host[:i]
+	}
+
+	return host
+}
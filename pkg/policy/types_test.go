@@ -0,0 +1,103 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package policy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetFindFirstMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Set{
+		Policies: []Policy{
+			{Name: "admin", Match: Match{Hosts: []string{"api.example.com"}, PathPrefix: "/admin", Methods: []string{"POST"}}},
+			{Name: "api", Match: Match{Hosts: []string{"api.example.com"}}},
+		},
+	}
+
+	assert.Equal("admin", s.Find("api.example.com:443", "/admin/users", "post").Name)
+	assert.Equal("api", s.Find("api.example.com", "/admin/users", "GET").Name)
+	assert.Nil(s.Find("other.example.com", "/", "GET"))
+}
+
+func TestMatchesPath(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &Match{PathPrefix: "/admin/"}
+
+	assert.True(m.MatchesPath("/admin"))
+	assert.True(m.MatchesPath("/admin/users"))
+	assert.True(m.MatchesPath("/public/../admin"))
+	assert.True(m.MatchesPath("//admin"))
+	assert.False(m.MatchesPath("/adminx"))
+	assert.False(m.MatchesPath("/admin/../public"))
+
+	assert.True((&Match{}).MatchesPath("/anything"))
+}
+
+func TestValidateRejectsUnknownRateLimitKey(t *testing.T) {
+	s := &Set{
+		Policies: []Policy{
+			{Name: "api", RateLimits: []RateLimit{{Key: "tenant", Requests: 1, Period: 1}}},
+		},
+	}
+
+	assert.NotNil(t, s.Validate())
+}
+
+func TestDefaultChain(t *testing.T) {
+	assert := assert.New(t)
+
+	var p *Policy
+
+	assert.Equal([]string{AuthenticatorAPIKey, AuthenticatorIntrospection, AuthenticatorClientCertificate, AuthenticatorKratosCookie}, p.Chain())
+	assert.Equal(ChainFirst, p.EvaluationMode())
+
+	p = &Policy{RelyingParty: true}
+	assert.Equal(AuthenticatorRelyingParty, p.Chain()[len(p.Chain())-1])
+
+	p = &Policy{Authenticators: []string{AuthenticatorJWT}, Mode: ChainAll}
+	assert.Equal([]string{AuthenticatorJWT}, p.Chain())
+	assert.Equal(ChainAll, p.EvaluationMode())
+}
+
+func TestValidateRejectsUnknownAuthenticator(t *testing.T) {
+	s := &Set{
+		Policies: []Policy{
+			{Name: "api", Authenticators: []string{"basic"}},
+		},
+	}
+
+	assert.NotNil(t, s.Validate())
+}
+
+func TestValidateDenyResponse(t *testing.T) {
+	for _, deny := range []*DenyResponse{
+		{Status: http.StatusOK},
+		{Body: DenyBody{JSON: `{"id": {{ json .RequestID }`}},
+		{Body: DenyBody{HTML: `<p>{{ .Missing`}},
+	} {
+		s := &Set{Policies: []Policy{{Name: "api", Deny: deny}}}
+
+		assert.NotNil(t, s.Validate())
+	}
+
+	s := &Set{Policies: []Policy{{Name: "api", Deny: &DenyResponse{Status: http.StatusNotFound, Body: DenyBody{JSON: `{"id": {{ json .RequestID }}}`}}}}}
+
+	assert.Nil(t, s.Validate())
+}
+
+func TestServiceAccounts(t *testing.T) {
+	p := &Policy{Name: "orders", ServiceAccounts: []string{"system:serviceaccount:shop:*", "system:serviceaccount:ops:backup"}}
+
+	assert.True(t, p.AllowsServiceAccount("system:serviceaccount:shop:checkout"))
+	assert.True(t, p.AllowsServiceAccount("system:serviceaccount:ops:backup"))
+	assert.False(t, p.AllowsServiceAccount("system:serviceaccount:ops:restore"))
+	assert.Nil(t, (&Set{Policies: []Policy{*p}}).Validate())
+	assert.NotNil(t, (&Set{Policies: []Policy{{Name: "orders", ServiceAccounts: []string{"shop:checkout"}}}}).Validate())
+}
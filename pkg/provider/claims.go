@@ -0,0 +1,84 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	kClient "github.com/ory/kratos-client-go"
+)
+
+// ClaimsMapping maps ID token claim names to dotted paths inside the kratos identity,
+// for example `email` to `traits.email`
+type ClaimsMapping map[string]string
+
+// Claims resolves the mapping against the identity, paths not found are left out
+func (m ClaimsMapping) Claims(identity *kClient.Identity) map[string]interface{} {
+	claims := make(map[string]interface{})
+
+	if identity == nil || len(m) == 0 {
+		return claims
+	}
+
+	raw, err := json.Marshal(identity)
+
+	if err != nil {
+		return claims
+	}
+
+	doc := make(map[string]interface{})
+
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return claims
+	}
+
+	for claim, path := range m {
+		if value, ok := lookup(doc, strings.Split(path, ".")); ok {
+			claims[claim] = value
+		}
+	}
+
+	return claims
+}
+
+func lookup(doc map[string]interface{}, path []string) (interface{}, bool) {
+	value, ok := doc[path[0]]
+
+	if !ok || len(path) == 1 {
+		return value, ok
+	}
+
+	nested, ok := value.(map[string]interface{})
+
+	if !ok {
+		return nil, false
+	}
+
+	return lookup(nested, path[1:])
+}
+
+// NewClaimsMapping parses a comma separated list of `claim=path` pairs
+func NewClaimsMapping(spec string) (ClaimsMapping, error) {
+	m := make(ClaimsMapping)
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+
+		if pair == "" {
+			continue
+		}
+
+		claim, path, found := strings.Cut(pair, "=")
+
+		if !found || claim == "" || path == "" {
+			return nil, fmt.Errorf("invalid claim mapping %q, expected claim=path", pair)
+		}
+
+		m[strings.TrimSpace(claim)] = strings.TrimSpace(path)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"testing"
+
+	kClient "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimsMapping(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := NewClaimsMapping("email=traits.email, given_name=traits.name.first,missing=traits.nope")
+	assert.Nil(err)
+
+	identity := kClient.NewIdentity("id", "default", "", map[string]interface{}{
+		"email": "user@example.com",
+		"name":  map[string]interface{}{"first": "Jane"},
+	})
+
+	claims := m.Claims(identity)
+
+	assert.Equal("user@example.com", claims["email"])
+	assert.Equal("Jane", claims["given_name"])
+	assert.NotContains(claims, "missing")
+}
+
+func TestClaimsMappingInvalid(t *testing.T) {
+	_, err := NewClaimsMapping("email")
+
+	assert.NotNil(t, err)
+}
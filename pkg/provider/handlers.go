@@ -0,0 +1,139 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+)
+
+// loginRequestedAtParam is added to the login URL kratos returns to, it carries the time the user
+// was sent to the login UI
+const loginRequestedAtParam = "login_requested_at"
+
+type API struct {
+	loginUIURL   string
+	consentUIURL string
+
+	service ServiceInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *API) RegisterEndpoints(mux *chi.Mux) {
+	mux.Get("/api/v0/oauth2/login", a.login)
+	mux.Get("/api/v0/oauth2/consent", a.consent)
+}
+
+func (a *API) login(w http.ResponseWriter, r *http.Request) {
+	challenge := r.URL.Query().Get("login_challenge")
+
+	if challenge == "" {
+		http.Error(w, "login_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	requestedAt := time.Time{}
+
+	if raw, err := strconv.ParseInt(r.URL.Query().Get(loginRequestedAtParam), 10, 64); err == nil {
+		requestedAt = time.Unix(raw, 0)
+	}
+
+	redirectTo, authenticated, err := a.service.AcceptLogin(r.Context(), challenge, requestedAt, r.Cookies())
+
+	reauthenticate := errors.Is(err, ErrReauthenticate)
+
+	if err != nil && !reauthenticate {
+		logging.FromContext(r.Context(), a.logger).Error(err)
+		http.Error(w, "Failed to handle login request", http.StatusInternalServerError)
+		return
+	}
+
+	if !authenticated {
+		// send the user to the login UI, kratos will bring them back here once done, the time
+		// tells the sessions authenticated since then apart
+		returnTo, _ := url.Parse(selfURL(r))
+		rq := returnTo.Query()
+		rq.Set(loginRequestedAtParam, strconv.FormatInt(time.Now().Unix(), 10))
+		returnTo.RawQuery = rq.Encode()
+
+		q := url.Values{}
+		q.Set("return_to", returnTo.String())
+
+		// the existing session is too old, kratos only asks for credentials again on a refresh
+		if reauthenticate {
+			q.Set("refresh", "true")
+		}
+
+		redirectTo = fmt.Sprintf("%s?%s", a.loginUIURL, q.Encode())
+	}
+
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+func (a *API) consent(w http.ResponseWriter, r *http.Request) {
+	challenge := r.URL.Query().Get("consent_challenge")
+
+	if challenge == "" {
+		http.Error(w, "consent_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	redirectTo, granted, err := a.service.AcceptConsent(r.Context(), challenge, r.Cookies())
+
+	if err != nil {
+		logging.FromContext(r.Context(), a.logger).Error(err)
+		http.Error(w, "Failed to handle consent request", http.StatusInternalServerError)
+		return
+	}
+
+	if !granted {
+		// third party clients need the user to consent, never grant on their behalf
+		if a.consentUIURL == "" {
+			http.Error(w, "Consent required", http.StatusForbidden)
+			return
+		}
+
+		q := url.Values{}
+		q.Set("consent_challenge", challenge)
+
+		redirectTo = fmt.Sprintf("%s?%s", a.consentUIURL, q.Encode())
+	}
+
+	http.Redirect(w, r, redirectTo, http.StatusFound)
+}
+
+// selfURL rebuilds the external URL of the request from the proxy headers
+func selfURL(r *http.Request) string {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+
+	if scheme == "" {
+		scheme = "http"
+
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+}
+
+func NewAPI(loginUIURL, consentUIURL string, service ServiceInterface, logger logging.LoggerInterface) *API {
+	a := new(API)
+
+	a.loginUIURL = loginUIURL
+	a.consentUIURL = consentUIURL
+	a.service = service
+	a.logger = logger
+
+	return a
+}
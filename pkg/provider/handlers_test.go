@@ -0,0 +1,104 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+)
+
+type fakeService struct {
+	ServiceInterface
+
+	granted bool
+	err     error
+}
+
+func (f *fakeService) AcceptLogin(context.Context, string, time.Time, []*http.Cookie) (string, bool, error) {
+	return "", false, f.err
+}
+
+func (f *fakeService) AcceptConsent(context.Context, string, []*http.Cookie) (string, bool, error) {
+	if !f.granted {
+		return "", false, nil
+	}
+
+	return "https://hydra.example.com/continue", true, nil
+}
+
+func TestConsent(t *testing.T) {
+	tests := []struct {
+		name         string
+		consentUIURL string
+		granted      bool
+		status       int
+		location     string
+	}{
+		{"granted", "https://login.example.com/consent", true, http.StatusFound, "https://hydra.example.com/continue"},
+		{"consent UI", "https://login.example.com/consent", false, http.StatusFound, "https://login.example.com/consent?consent_challenge=abc"},
+		{"no consent UI", "", false, http.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mux := chi.NewMux()
+			NewAPI("https://login.example.com/login", test.consentUIURL, &fakeService{granted: test.granted}, logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/oauth2/consent?consent_challenge=abc", nil))
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		refresh bool
+	}{
+		{"no session", nil, http.StatusFound, false},
+		{"session too old", ErrReauthenticate, http.StatusFound, true},
+		{"failure", fmt.Errorf("hydra unavailable"), http.StatusInternalServerError, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mux := chi.NewMux()
+			NewAPI("https://login.example.com/login", "", &fakeService{err: test.err}, logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://auth.example.com/api/v0/oauth2/login?login_challenge=abc", nil))
+
+			assert.Equal(test.status, w.Code)
+
+			if test.status != http.StatusFound {
+				return
+			}
+
+			location, _ := url.Parse(w.Header().Get("Location"))
+			returnTo, _ := url.Parse(location.Query().Get("return_to"))
+
+			assert.Equal("login.example.com", location.Host)
+			assert.Equal(test.refresh, location.Query().Get("refresh") == "true")
+			assert.Equal("abc", returnTo.Query().Get("login_challenge"))
+			assert.NotEmpty(returnTo.Query().Get(loginRequestedAtParam), "the return URL carries when the login was requested")
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	hClient "github.com/ory/hydra-client-go/v2"
+	kClient "github.com/ory/kratos-client-go"
+)
+
+type KratosClientInterface interface {
+	FrontendAPI() kClient.FrontendAPI
+}
+
+type HydraClientInterface interface {
+	OAuth2API() hClient.OAuth2API
+}
+
+type ServiceInterface interface {
+	AcceptLogin(context.Context, string, time.Time, []*http.Cookie) (string, bool, error)
+	AcceptConsent(context.Context, string, []*http.Cookie) (string, bool, error)
+}
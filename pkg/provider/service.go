@@ -0,0 +1,248 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	hClient "github.com/ory/hydra-client-go/v2"
+	kClient "github.com/ory/kratos-client-go"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+// claimsContextKey is where the mapped claims are stored in the login context, hydra hands
+// it over to the consent request when the kratos session is not around anymore
+const claimsContextKey = "id_token_claims"
+
+// authenticatedAtSkew absorbs the clock drift between kratos and the authorizer when checking
+// a session was authenticated after the user was sent to the login UI
+const authenticatedAtSkew = 5 * time.Second
+
+// ErrReauthenticate is returned when the kratos session can't answer the login request, asking
+// for prompt=login or a max_age the session is older than, the user has to authenticate again
+var ErrReauthenticate = errors.New("login request requires a new authentication")
+
+type Service struct {
+	kratos KratosClientInterface
+	hydra  HydraClientInterface
+
+	claims             ClaimsMapping
+	loginRememberFor   time.Duration
+	consentRememberFor time.Duration
+	// trustedClients are the first party clients consent is granted to without asking the user
+	trustedClients map[string]bool
+
+	tracer  tracing.TracingInterface
+	monitor monitoring.MonitorInterface
+	logger  logging.LoggerInterface
+}
+
+// AcceptLogin accepts the hydra login request if it can be skipped or if a kratos session is
+// available, the boolean is false when the user needs to authenticate first; sessions have to be
+// authenticated after requestedAt, when the user was sent to the login UI, or within max_age to
+// answer requests with prompt=login or max_age, ErrReauthenticate is returned otherwise
+func (s *Service) AcceptLogin(ctx context.Context, challenge string, requestedAt time.Time, cookies []*http.Cookie) (string, bool, error) {
+	ctx, span := s.tracer.Start(ctx, "provider.Service.AcceptLogin")
+	defer span.End()
+
+	loginRequest, _, err := s.hydra.OAuth2API().GetOAuth2LoginRequest(ctx).LoginChallenge(challenge).Execute()
+
+	if err != nil {
+		return "", false, fmt.Errorf("unable to fetch login request: %w", err)
+	}
+
+	session := s.session(ctx, cookies)
+
+	accept := hClient.NewAcceptOAuth2LoginRequest(loginRequest.Subject)
+
+	switch {
+	case loginRequest.Skip:
+		// hydra already authenticated the subject, remember must not be set again
+	case session != nil:
+		if !fresh(loginRequest.RequestUrl, session.GetAuthenticatedAt(), requestedAt) {
+			return "", false, ErrReauthenticate
+		}
+
+		accept.SetSubject(session.GetIdentity().Id)
+		accept.SetIdentityProviderSessionId(session.Id)
+
+		if s.loginRememberFor > 0 {
+			accept.SetRemember(true)
+			accept.SetRememberFor(int64(s.loginRememberFor.Seconds()))
+		}
+	default:
+		return "", false, nil
+	}
+
+	if session != nil && session.GetIdentity().Id == accept.Subject {
+		accept.SetContext(map[string]interface{}{claimsContextKey: s.claims.Claims(session.Identity)})
+	}
+
+	redirect, _, err := s.hydra.OAuth2API().AcceptOAuth2LoginRequest(ctx).LoginChallenge(challenge).AcceptOAuth2LoginRequest(*accept).Execute()
+
+	if err != nil {
+		return "", false, fmt.Errorf("unable to accept login request: %w", err)
+	}
+
+	return redirect.RedirectTo, true, nil
+}
+
+// AcceptConsent grants the requested scopes and audiences when hydra can skip the consent or
+// the client is trusted, the boolean is false when the user has to be asked through the consent UI
+func (s *Service) AcceptConsent(ctx context.Context, challenge string, cookies []*http.Cookie) (string, bool, error) {
+	ctx, span := s.tracer.Start(ctx, "provider.Service.AcceptConsent")
+	defer span.End()
+
+	consentRequest, _, err := s.hydra.OAuth2API().GetOAuth2ConsentRequest(ctx).ConsentChallenge(challenge).Execute()
+
+	if err != nil {
+		return "", false, fmt.Errorf("unable to fetch consent request: %w", err)
+	}
+
+	if !consentRequest.GetSkip() && !s.trusted(consentRequest.Client) {
+		return "", false, nil
+	}
+
+	accept := hClient.NewAcceptOAuth2ConsentRequest()
+	accept.SetGrantScope(consentRequest.RequestedScope)
+	accept.SetGrantAccessTokenAudience(consentRequest.RequestedAccessTokenAudience)
+
+	if !consentRequest.GetSkip() && s.consentRememberFor > 0 {
+		accept.SetRemember(true)
+		accept.SetRememberFor(int64(s.consentRememberFor.Seconds()))
+	}
+
+	claims := s.contextClaims(consentRequest.Context)
+
+	if session := s.session(ctx, cookies); session != nil {
+		if session.GetIdentity().Id != consentRequest.GetSubject() {
+			return "", false, fmt.Errorf("kratos session identity doesn't match consent subject %s", consentRequest.GetSubject())
+		}
+
+		claims = s.claims.Claims(session.Identity)
+	}
+
+	accept.SetSession(hClient.AcceptOAuth2ConsentRequestSession{IdToken: claims})
+
+	redirect, _, err := s.hydra.OAuth2API().AcceptOAuth2ConsentRequest(ctx).ConsentChallenge(challenge).AcceptOAuth2ConsentRequest(*accept).Execute()
+
+	if err != nil {
+		return "", false, fmt.Errorf("unable to accept consent request: %w", err)
+	}
+
+	return redirect.RedirectTo, true, nil
+}
+
+// fresh tells if a session authenticated at authenticatedAt satisfies the prompt and max_age of
+// the authorization request, sessions authenticated after requestedAt always do
+func fresh(requestURL string, authenticatedAt, requestedAt time.Time) bool {
+	if !requestedAt.IsZero() && !authenticatedAt.Add(authenticatedAtSkew).Before(requestedAt) {
+		return true
+	}
+
+	u, err := url.Parse(requestURL)
+
+	if err != nil {
+		return false
+	}
+
+	q := u.Query()
+
+	if slices.Contains(strings.Fields(q.Get("prompt")), "login") {
+		return false
+	}
+
+	if raw := q.Get("max_age"); raw != "" {
+		maxAge, err := strconv.ParseInt(raw, 10, 64)
+
+		if err != nil || time.Since(authenticatedAt) > time.Duration(maxAge)*time.Second {
+			return false
+		}
+	}
+
+	return true
+}
+
+// trusted tells if the client is a first party one, either listed in the configuration or
+// flagged with skip_consent in hydra
+func (s *Service) trusted(client *hClient.OAuth2Client) bool {
+	if client == nil {
+		return false
+	}
+
+	return client.GetSkipConsent() || s.trustedClients[client.GetClientId()]
+}
+
+func (s *Service) session(ctx context.Context, cookies []*http.Cookie) *kClient.Session {
+	if len(cookies) == 0 {
+		return nil
+	}
+
+	ctx, span := s.tracer.Start(ctx, "kratos.FrontendAPI.ToSession")
+	defer span.End()
+
+	strCookie := make([]string, 0)
+
+	for _, c := range cookies {
+		strCookie = append(strCookie, c.String())
+	}
+
+	session, _, err := s.kratos.FrontendAPI().
+		ToSession(ctx).
+		Cookie(strings.Join(strCookie, "; ")).
+		Execute()
+
+	if err != nil || !session.GetActive() {
+		logging.FromContext(ctx, s.logger).Debugf("no active kratos session: %v", err)
+		return nil
+	}
+
+	return session
+}
+
+func (s *Service) contextClaims(loginContext interface{}) map[string]interface{} {
+	if c, ok := loginContext.(map[string]interface{}); ok {
+		if claims, ok := c[claimsContextKey].(map[string]interface{}); ok {
+			return claims
+		}
+	}
+
+	return make(map[string]interface{})
+}
+
+func NewService(
+	kratos KratosClientInterface, hydra HydraClientInterface, claims ClaimsMapping, loginRememberFor, consentRememberFor time.Duration, trustedClients []string,
+	tracer tracing.TracingInterface, monitor monitoring.MonitorInterface, logger logging.LoggerInterface,
+) *Service {
+	s := new(Service)
+
+	s.kratos = kratos
+	s.hydra = hydra
+
+	s.claims = claims
+	s.loginRememberFor = loginRememberFor
+	s.consentRememberFor = consentRememberFor
+	s.trustedClients = make(map[string]bool, len(trustedClients))
+
+	for _, client := range trustedClients {
+		s.trustedClients[client] = true
+	}
+
+	s.monitor = monitor
+	s.tracer = tracer
+	s.logger = logger
+
+	return s
+}
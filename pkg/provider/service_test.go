@@ -0,0 +1,201 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/hydra"
+	"github.com/shipperizer/iam-ext-authz/internal/kratos"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+// fakeOry serves the hydra admin login and consent requests and the kratos whoami endpoint,
+// the accepted requests are recorded
+type fakeOry struct {
+	login   map[string]interface{}
+	consent map[string]interface{}
+	// identity is the kratos session identity, no session when empty
+	identity string
+	// authenticatedAt is when the kratos session was authenticated, defaults to now
+	authenticatedAt time.Time
+
+	accepted map[string]interface{}
+}
+
+func (f *fakeOry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/admin/oauth2/auth/requests/login":
+		_ = json.NewEncoder(w).Encode(f.login)
+	case "/admin/oauth2/auth/requests/consent":
+		_ = json.NewEncoder(w).Encode(f.consent)
+	case "/admin/oauth2/auth/requests/login/accept", "/admin/oauth2/auth/requests/consent/accept":
+		_ = json.NewDecoder(r.Body).Decode(&f.accepted)
+		_ = json.NewEncoder(w).Encode(map[string]string{"redirect_to": "https://hydra.example.com/continue"})
+	case "/sessions/whoami":
+		if f.identity == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":401,"message":"no session"}}`))
+			return
+		}
+
+		authenticatedAt := f.authenticatedAt
+
+		if authenticatedAt.IsZero() {
+			authenticatedAt = time.Now()
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":               "session",
+			"active":           true,
+			"authenticated_at": authenticatedAt,
+			"identity": map[string]interface{}{
+				"id":         f.identity,
+				"schema_id":  "default",
+				"schema_url": "",
+				"traits":     map[string]interface{}{"email": f.identity + "@example.com"},
+			},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestService(t *testing.T, ory *fakeOry, trustedClients ...string) *Service {
+	srv := httptest.NewServer(ory)
+	t.Cleanup(srv.Close)
+
+	claims, _ := NewClaimsMapping("email=traits.email")
+	logger := logging.NewNoopLogger()
+
+	return NewService(
+		kratos.NewClient(srv.URL, false), hydra.NewClient(srv.URL, false), claims, 24*time.Hour, 720*time.Hour, trustedClients,
+		tracing.NewNoopTracer(), monitoring.NewNoopMonitor("test", logger), logger,
+	)
+}
+
+var sessionCookie = []*http.Cookie{{Name: "ory_kratos_session", Value: "token"}}
+
+func loginRequest(skip bool, subject string) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge":   "challenge",
+		"client":      map[string]interface{}{"client_id": "app"},
+		"request_url": "https://hydra.example.com/oauth2/auth",
+		"skip":        skip,
+		"subject":     subject,
+	}
+}
+
+func TestAcceptLogin(t *testing.T) {
+	hourAgo := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name            string
+		skip            bool
+		subject         string
+		identity        string
+		query           string
+		authenticatedAt time.Time
+		requestedAt     time.Time
+		authenticated   bool
+		remember        bool
+		claims          bool
+		err             error
+	}{
+		{name: "skip", skip: true, subject: "alice", authenticated: true},
+		{name: "skip with the session of the subject", skip: true, subject: "alice", identity: "alice", authenticated: true, claims: true},
+		{name: "skip with the session of another identity", skip: true, subject: "alice", identity: "bob", authenticated: true},
+		{name: "session", identity: "alice", authenticated: true, remember: true, claims: true},
+		{name: "no session"},
+		{name: "prompt login", identity: "alice", query: "?prompt=login", err: ErrReauthenticate},
+		{name: "prompt login after authenticating again", identity: "alice", query: "?prompt=login", requestedAt: time.Now().Add(-time.Minute), authenticated: true, remember: true, claims: true},
+		{name: "prompt login with a session older than the request", identity: "alice", query: "?prompt=login", authenticatedAt: hourAgo, requestedAt: time.Now().Add(-time.Minute), err: ErrReauthenticate},
+		{name: "max age", identity: "alice", query: "?max_age=7200", authenticatedAt: hourAgo, authenticated: true, remember: true, claims: true},
+		{name: "session older than max age", identity: "alice", query: "?max_age=60", authenticatedAt: hourAgo, err: ErrReauthenticate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ory := &fakeOry{login: loginRequest(test.skip, test.subject), identity: test.identity, authenticatedAt: test.authenticatedAt}
+			ory.login["request_url"] = ory.login["request_url"].(string) + test.query
+
+			redirect, authenticated, err := newTestService(t, ory).AcceptLogin(context.TODO(), "challenge", test.requestedAt, sessionCookie)
+
+			assert.Equal(test.err, err)
+			assert.Equal(test.authenticated, authenticated)
+
+			if !test.authenticated {
+				assert.Nil(ory.accepted)
+				return
+			}
+
+			assert.Equal("https://hydra.example.com/continue", redirect)
+			assert.Equal("alice", ory.accepted["subject"])
+			assert.Equal(test.remember, ory.accepted["remember"] == true)
+			assert.Equal(test.claims, ory.accepted["context"] != nil, "claims are only carried for the session of the subject")
+		})
+	}
+}
+
+func TestAcceptConsent(t *testing.T) {
+	tests := []struct {
+		name     string
+		skip     bool
+		client   map[string]interface{}
+		identity string
+		granted  bool
+		remember bool
+		err      bool
+	}{
+		{name: "skip", skip: true, client: map[string]interface{}{"client_id": "third-party"}, granted: true},
+		{name: "trusted client", client: map[string]interface{}{"client_id": "app"}, identity: "alice", granted: true, remember: true},
+		{name: "skip_consent client", client: map[string]interface{}{"client_id": "other", "skip_consent": true}, granted: true, remember: true},
+		{name: "third party client", client: map[string]interface{}{"client_id": "third-party"}, identity: "alice"},
+		{name: "subject mismatch", client: map[string]interface{}{"client_id": "app"}, identity: "bob", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ory := &fakeOry{
+				consent: map[string]interface{}{
+					"challenge":       "challenge",
+					"client":          test.client,
+					"skip":            test.skip,
+					"subject":         "alice",
+					"requested_scope": []string{"openid", "email"},
+				},
+				identity: test.identity,
+			}
+
+			redirect, granted, err := newTestService(t, ory, "app").AcceptConsent(context.TODO(), "challenge", sessionCookie)
+
+			assert.Equal(test.err, err != nil)
+			assert.Equal(test.granted, granted)
+
+			if !test.granted {
+				assert.Nil(ory.accepted, "consent is never granted on behalf of the user")
+				return
+			}
+
+			assert.Equal("https://hydra.example.com/continue", redirect)
+			assert.Equal([]interface{}{"openid", "email"}, ory.accepted["grant_scope"])
+			assert.Equal(test.remember, ory.accepted["remember"] == true)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package relyingparty
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// CookieCodec seals cookie values with AES-GCM so that they can't be read or tampered with
+type CookieCodec struct {
+	aead cipher.AEAD
+}
+
+func (c *CookieCodec) Encode(v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func (c *CookieCodec) Decode(value string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return err
+	}
+
+	if len(raw) < c.aead.NonceSize() {
+		return fmt.Errorf("cookie value too short")
+	}
+
+	nonce, ciphertext := raw[:c.aead.NonceSize()], raw[c.aead.NonceSize():]
+
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
+
+// NewCookieCodec builds a codec from a base64 encoded 16, 24 or 32 bytes key
+func NewCookieCodec(key string) (*CookieCodec, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("cookie key is not valid base64: %w", err)
+	}
+
+	block, err := aes.NewCipher(rawKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie key: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(CookieCodec)
+	c.aead = aead
+
+	return c, nil
+}
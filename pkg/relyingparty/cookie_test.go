@@ -0,0 +1,33 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package relyingparty
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieCodecRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	codec, err := NewCookieCodec(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	assert.Nil(err)
+
+	value, err := codec.Encode(Session{Subject: "user"})
+	assert.Nil(err)
+
+	session := new(Session)
+	assert.Nil(codec.Decode(value, session))
+	assert.Equal("user", session.Subject)
+
+	assert.NotNil(codec.Decode(value[:len(value)-2]+"xx", session), "tampered cookie should be rejected")
+}
+
+func TestCookieCodecInvalidKey(t *testing.T) {
+	_, err := NewCookieCodec(base64.StdEncoding.EncodeToString([]byte("short")))
+
+	assert.NotNil(t, err)
+}
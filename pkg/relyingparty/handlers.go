@@ -0,0 +1,83 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package relyingparty
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+)
+
+type API struct {
+	service ServiceInterface
+
+	logger logging.LoggerInterface
+}
+
+func (a *API) RegisterEndpoints(mux *chi.Mux) {
+	mux.Get("/api/v0/rp/callback", a.callback)
+	mux.Get("/api/v0/rp/logout", a.logout)
+}
+
+func (a *API) callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if e := q.Get("error"); e != "" {
+		logging.FromContext(r.Context(), a.logger).Infof("authorization failed: %s %s", e, q.Get("error_description"))
+		http.Error(w, "Authorization failed", http.StatusForbidden)
+		return
+	}
+
+	session, returnTo, err := a.service.Exchange(r.Context(), q.Get("code"), q.Get("state"), r)
+
+	if err != nil {
+		logging.FromContext(r.Context(), a.logger).Errorf("relying party callback failed: %v", err)
+		http.Error(w, "Failed to complete authorization", http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := a.service.SessionCookie(session)
+
+	if err != nil {
+		logging.FromContext(r.Context(), a.logger).Errorf("unable to encode session cookie: %v", err)
+		http.Error(w, "Failed to complete authorization", http.StatusInternalServerError)
+		return
+	}
+
+	// drop the state cookie, the session one is overwritten below
+	for _, c := range a.service.ClearCookies() {
+		if c.Name != cookie.Name {
+			http.SetCookie(w, c)
+		}
+	}
+
+	http.SetCookie(w, cookie)
+
+	if returnTo == "" {
+		returnTo = "/"
+	}
+
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+func (a *API) logout(w http.ResponseWriter, r *http.Request) {
+	session := a.service.Session(r)
+
+	for _, c := range a.service.ClearCookies() {
+		http.SetCookie(w, c)
+	}
+
+	http.Redirect(w, r, a.service.LogoutURL(session), http.StatusFound)
+}
+
+func NewAPI(service ServiceInterface, logger logging.LoggerInterface) *API {
+	a := new(API)
+
+	a.service = service
+	a.logger = logger
+
+	return a
+}
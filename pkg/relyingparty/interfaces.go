@@ -0,0 +1,17 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package relyingparty
+
+import (
+	"context"
+	"net/http"
+)
+
+type ServiceInterface interface {
+	Exchange(context.Context, string, string, *http.Request) (*Session, string, error)
+	SessionCookie(*Session) (*http.Cookie, error)
+	ClearCookies() []*http.Cookie
+	Session(*http.Request) *Session
+	LogoutURL(*Session) string
+}
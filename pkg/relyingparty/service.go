@@ -0,0 +1,500 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package relyingparty
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const (
+	stateCookieSuffix = "_state"
+	stateTTL          = 10 * time.Minute
+	// refreshReuseWindow is how long the outcome of a refresh is handed to the requests still
+	// carrying the old session cookie, they race with the browser storing the new one
+	refreshReuseWindow = 30 * time.Second
+)
+
+// ErrNoRefreshToken is returned when an expired session can't be refreshed
+var ErrNoRefreshToken = errors.New("session has no refresh token")
+
+// Session is what gets stored in the encrypted session cookie
+type Session struct {
+	Subject   string    `json:"sub"`
+	IDToken   string    `json:"id_token,omitempty"`
+	ExpiresAt time.Time `json:"exp"`
+	// RefreshToken is issued when offline_access is granted, it renews the session once expired
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// authState holds the PKCE verifier and anti-CSRF values between authorize and callback
+type authState struct {
+	State     string    `json:"state"`
+	Verifier  string    `json:"verifier"`
+	Nonce     string    `json:"nonce"`
+	ReturnTo  string    `json:"return_to"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// refreshed is the outcome of a refresh, reused by the concurrent requests of the same session
+type refreshed struct {
+	session   *Session
+	cookie    *http.Cookie
+	expiresAt time.Time
+}
+
+type idTokenClaims struct {
+	Issuer   string      `json:"iss"`
+	Subject  string      `json:"sub"`
+	Audience interface{} `json:"aud"`
+	Nonce    string      `json:"nonce"`
+	Expiry   int64       `json:"exp"`
+}
+
+// Config carries the relying party settings
+type Config struct {
+	IssuerURL             string
+	ClientID              string
+	ClientSecret          string
+	RedirectURL           string
+	PostLogoutRedirectURL string
+	Scopes                []string
+	CookieName            string
+	CookieDomain          string
+}
+
+type Service struct {
+	oauth2 *oauth2.Config
+	codec  *CookieCodec
+	issuer string
+
+	cookieName            string
+	cookieDomain          string
+	endSessionURL         string
+	postLogoutRedirectURL string
+
+	// refreshes deduplicates concurrent refreshes of a session, hydra rotates refresh tokens
+	// and revokes the whole chain when one is used twice
+	refreshes singleflight.Group
+	refreshed map[string]*refreshed
+	mu        sync.Mutex
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+// AuthCodeURL starts an authorization code flow with PKCE, the returned cookie needs to be
+// set on the browser for the callback to succeed
+func (s *Service) AuthCodeURL(returnTo string) (string, *http.Cookie, error) {
+	state := new(authState)
+	state.State = randomString()
+	state.Nonce = randomString()
+	state.Verifier = oauth2.GenerateVerifier()
+	state.ReturnTo = returnTo
+	state.ExpiresAt = time.Now().Add(stateTTL)
+
+	value, err := s.codec.Encode(state)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	authURL := s.oauth2.AuthCodeURL(
+		state.State,
+		oauth2.S256ChallengeOption(state.Verifier),
+		oauth2.SetAuthURLParam("nonce", state.Nonce),
+	)
+
+	return authURL, s.cookie(s.cookieName+stateCookieSuffix, value, state.ExpiresAt), nil
+}
+
+// Exchange completes the flow started by AuthCodeURL and returns the new session along
+// with the URL the user was originally heading to
+func (s *Service) Exchange(ctx context.Context, code, state string, r *http.Request) (*Session, string, error) {
+	ctx, span := s.tracer.Start(ctx, "relyingparty.Service.Exchange")
+	defer span.End()
+
+	c, err := r.Cookie(s.cookieName + stateCookieSuffix)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("missing state cookie")
+	}
+
+	stored := new(authState)
+
+	if err := s.codec.Decode(c.Value, stored); err != nil {
+		return nil, "", fmt.Errorf("invalid state cookie: %w", err)
+	}
+
+	if stored.State != state || time.Now().After(stored.ExpiresAt) {
+		return nil, "", fmt.Errorf("state mismatch or expired")
+	}
+
+	token, err := s.oauth2.Exchange(ctx, code, oauth2.VerifierOption(stored.Verifier))
+
+	if err != nil {
+		return nil, "", fmt.Errorf("code exchange failed: %w", err)
+	}
+
+	session, err := s.session(token, stored.Nonce)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, stored.ReturnTo, nil
+}
+
+// Refresh renews the expired session carried by the request with its refresh token, the
+// returned cookie replaces the session one; concurrent refreshes of a session share a single
+// token request and its outcome is reused for a short while
+func (s *Service) Refresh(ctx context.Context, r *http.Request) (*Session, *http.Cookie, error) {
+	ctx, span := s.tracer.Start(ctx, "relyingparty.Service.Refresh")
+	defer span.End()
+
+	c, err := r.Cookie(s.cookieName)
+
+	if err != nil {
+		return nil, nil, ErrNoRefreshToken
+	}
+
+	expired := new(Session)
+
+	if err := s.codec.Decode(c.Value, expired); err != nil || expired.RefreshToken == "" {
+		return nil, nil, ErrNoRefreshToken
+	}
+
+	key := refreshKey(expired.Subject, expired.RefreshToken)
+
+	if res := s.recentRefresh(key); res != nil {
+		return res.session, res.cookie, nil
+	}
+
+	v, err, _ := s.refreshes.Do(key, func() (interface{}, error) {
+		// a refresh of the same token may have completed since the check above
+		if res := s.recentRefresh(key); res != nil {
+			return res, nil
+		}
+
+		res, err := s.refresh(ctx, expired)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.remember(key, res)
+
+		return res, nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := v.(*refreshed)
+	cookie := *res.cookie
+
+	return res.session, &cookie, nil
+}
+
+// refresh redeems the refresh token of the expired session
+func (s *Service) refresh(ctx context.Context, expired *Session) (*refreshed, error) {
+	token, err := s.oauth2.TokenSource(ctx, &oauth2.Token{RefreshToken: expired.RefreshToken}).Token()
+
+	if err != nil {
+		return nil, fmt.Errorf("refresh failed: %w", err)
+	}
+
+	// the nonce is only carried by the ID token of the authorization code flow
+	session, err := s.session(token, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Subject != expired.Subject {
+		return nil, fmt.Errorf("refreshed id_token subject doesn't match the session")
+	}
+
+	// hydra rotates refresh tokens, keep the old one if no new one was issued
+	if session.RefreshToken == "" {
+		session.RefreshToken = expired.RefreshToken
+	}
+
+	cookie, err := s.SessionCookie(session)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(refreshed)
+	res.session = session
+	res.cookie = cookie
+	res.expiresAt = time.Now().Add(refreshReuseWindow)
+
+	return res, nil
+}
+
+// recentRefresh returns the outcome of a refresh of the same refresh token within the reuse
+// window, nil otherwise
+func (s *Service) recentRefresh(key string) *refreshed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, ok := s.refreshed[key]
+
+	if !ok || time.Now().After(res.expiresAt) {
+		return nil
+	}
+
+	cookie := *res.cookie
+
+	return &refreshed{session: res.session, cookie: &cookie, expiresAt: res.expiresAt}
+}
+
+// session builds the session from the token response, the ID token comes straight from the
+// token endpoint over TLS so, as allowed by OpenID Connect Core 3.1.3.7, the signature check is
+// skipped while issuer, audience, expiry and nonce are validated
+func (s *Service) session(token *oauth2.Token, nonce string) (*Session, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+
+	if !ok {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := decodeIDToken(rawIDToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != s.issuer {
+		return nil, fmt.Errorf("id_token issued by %s", claims.Issuer)
+	}
+
+	if !audienceContains(claims.Audience, s.oauth2.ClientID) {
+		return nil, fmt.Errorf("id_token not issued for client %s", s.oauth2.ClientID)
+	}
+
+	// the session lasts as long as the ID token, an expired or missing expiry would send the
+	// browser straight back to the authorization server
+	expiresAt := time.Unix(claims.Expiry, 0)
+
+	if claims.Expiry == 0 || !time.Now().Before(expiresAt) {
+		return nil, fmt.Errorf("id_token expired or without expiry")
+	}
+
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id_token has no subject")
+	}
+
+	session := new(Session)
+	session.Subject = claims.Subject
+	session.IDToken = rawIDToken
+	session.ExpiresAt = expiresAt
+	session.RefreshToken = token.RefreshToken
+
+	return session, nil
+}
+
+// SessionCookie returns the encrypted session cookie, it outlives the session until the browser
+// is closed when a refresh token can renew it
+func (s *Service) SessionCookie(session *Session) (*http.Cookie, error) {
+	value, err := s.codec.Encode(session)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expires := session.ExpiresAt
+
+	if session.RefreshToken != "" {
+		expires = time.Time{}
+	}
+
+	return s.cookie(s.cookieName, value, expires), nil
+}
+
+// ClearCookies returns the cookies needed to drop both session and state from the browser
+func (s *Service) ClearCookies() []*http.Cookie {
+	cookies := make([]*http.Cookie, 0)
+
+	for _, name := range []string{s.cookieName, s.cookieName + stateCookieSuffix} {
+		c := s.cookie(name, "", time.Unix(0, 0))
+		c.MaxAge = -1
+
+		cookies = append(cookies, c)
+	}
+
+	return cookies
+}
+
+// Session returns the session carried by the request cookie, nil if missing, invalid or expired
+func (s *Service) Session(r *http.Request) *Session {
+	c, err := r.Cookie(s.cookieName)
+
+	if err != nil {
+		return nil
+	}
+
+	session := new(Session)
+
+	if err := s.codec.Decode(c.Value, session); err != nil {
+		logging.FromContext(r.Context(), s.logger).Debugf("invalid relying party session cookie: %v", err)
+		return nil
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return nil
+	}
+
+	return session
+}
+
+// LogoutURL points to the hydra end session endpoint
+func (s *Service) LogoutURL(session *Session) string {
+	q := url.Values{}
+
+	if session != nil && session.IDToken != "" {
+		q.Set("id_token_hint", session.IDToken)
+	}
+
+	if s.postLogoutRedirectURL != "" {
+		q.Set("post_logout_redirect_uri", s.postLogoutRedirectURL)
+	}
+
+	return fmt.Sprintf("%s?%s", s.endSessionURL, q.Encode())
+}
+
+func (s *Service) cookie(name, value string, expires time.Time) *http.Cookie {
+	c := new(http.Cookie)
+
+	c.Name = name
+	c.Value = value
+	c.Path = "/"
+	c.Domain = s.cookieDomain
+	c.Expires = expires
+	c.Secure = true
+	c.HttpOnly = true
+	c.SameSite = http.SameSiteLaxMode
+
+	return c
+}
+
+func decodeIDToken(raw string) (*idTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id_token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token payload: %w", err)
+	}
+
+	claims := new(idTokenClaims)
+
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		return slices.Contains(v, interface{}(clientID))
+	}
+
+	return false
+}
+
+// remember keeps the outcome of a refresh for the reuse window, dropping the stale ones
+func (s *Service) remember(key string, res *refreshed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for k, old := range s.refreshed {
+		if now.After(old.expiresAt) {
+			delete(s.refreshed, k)
+		}
+	}
+
+	s.refreshed[key] = res
+}
+
+// refreshKey identifies the refresh token of the subject without keeping it in memory
+func refreshKey(subject, token string) string {
+	sum := sha256.Sum256([]byte(subject + "\x00" + token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+
+	// crypto/rand never fails on supported platforms
+	_, _ = rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func NewService(cfg *Config, codec *CookieCodec, tracer tracing.TracingInterface, logger logging.LoggerInterface) *Service {
+	s := new(Service)
+
+	issuer := strings.TrimSuffix(cfg.IssuerURL, "/")
+
+	s.oauth2 = &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  issuer + "/oauth2/auth",
+			TokenURL: issuer + "/oauth2/token",
+		},
+	}
+	s.codec = codec
+	s.issuer = issuer
+
+	s.cookieName = cfg.CookieName
+	s.cookieDomain = cfg.CookieDomain
+	s.endSessionURL = issuer + "/oauth2/sessions/logout"
+	s.postLogoutRedirectURL = cfg.PostLogoutRedirectURL
+	s.refreshed = make(map[string]*refreshed)
+
+	s.tracer = tracer
+	s.logger = logger
+
+	return s
+}
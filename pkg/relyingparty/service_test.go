@@ -0,0 +1,219 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package relyingparty
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+// fakeHydra serves the token endpoint, the PKCE verifier is checked against the challenge of
+// the authorization request and the ID token claims can be altered by the test
+type fakeHydra struct {
+	issuer    string
+	challenge string
+	nonce     string
+	mutate    func(claims map[string]interface{})
+	refreshes atomic.Int32
+}
+
+func (f *fakeHydra) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Form.Get("grant_type") == "authorization_code" {
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+	}
+
+	if r.Form.Get("grant_type") == "refresh_token" {
+		f.refreshes.Add(1)
+	}
+
+	claims := map[string]interface{}{"iss": f.issuer, "sub": "alice", "aud": []string{"app"}, "exp": time.Now().Add(time.Hour).Unix()}
+
+	if f.nonce != "" {
+		claims["nonce"] = f.nonce
+	}
+
+	if f.mutate != nil {
+		f.mutate(claims)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  "access",
+		"token_type":    "bearer",
+		"refresh_token": "refresh",
+		"id_token":      unsignedJWT(claims),
+	})
+}
+
+func unsignedJWT(claims map[string]interface{}) string {
+	payload, _ := json.Marshal(claims)
+
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+func newTestService(t *testing.T, hydra *fakeHydra) *Service {
+	srv := httptest.NewServer(hydra)
+	t.Cleanup(srv.Close)
+
+	hydra.issuer = srv.URL + "/"
+
+	codec, _ := NewCookieCodec(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+
+	cfg := &Config{
+		IssuerURL:   srv.URL,
+		ClientID:    "app",
+		RedirectURL: "https://app.example.com/api/v0/rp/callback",
+		Scopes:      []string{"openid", "offline_access"},
+		CookieName:  "session",
+	}
+
+	return NewService(cfg, codec, tracing.NewNoopTracer(), logging.NewNoopLogger())
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(map[string]interface{})
+		state  string
+		pkce   bool
+		err    bool
+	}{
+		{name: "valid"},
+		{name: "state mismatch", state: "other", err: true},
+		{name: "pkce verifier mismatch", pkce: true, err: true},
+		{name: "nonce mismatch", mutate: func(c map[string]interface{}) { c["nonce"] = "other" }, err: true},
+		{name: "other issuer", mutate: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, err: true},
+		{name: "other audience", mutate: func(c map[string]interface{}) { c["aud"] = "other" }, err: true},
+		{name: "missing expiry", mutate: func(c map[string]interface{}) { delete(c, "exp") }, err: true},
+		{name: "expired", mutate: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			hydra := &fakeHydra{mutate: test.mutate}
+			s := newTestService(t, hydra)
+
+			authURL, cookie, err := s.AuthCodeURL("https://app.example.com/orders")
+			assert.Nil(err)
+
+			u, _ := url.Parse(authURL)
+			hydra.challenge = u.Query().Get("code_challenge")
+			hydra.nonce = u.Query().Get("nonce")
+
+			if test.pkce {
+				hydra.challenge = "other"
+			}
+
+			state := u.Query().Get("state")
+
+			if test.state != "" {
+				state = test.state
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/rp/callback", nil)
+			r.AddCookie(cookie)
+
+			session, returnTo, err := s.Exchange(context.TODO(), "code", state, r)
+
+			if test.err {
+				assert.NotNil(err)
+				return
+			}
+
+			assert.Nil(err)
+			assert.Equal("https://app.example.com/orders", returnTo)
+			assert.Equal("alice", session.Subject)
+			assert.Equal("refresh", session.RefreshToken, "the offline_access refresh token is kept")
+			assert.True(session.ExpiresAt.After(time.Now()))
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestService(t, new(fakeHydra))
+
+	request := func(session *Session) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		cookie, _ := s.SessionCookie(session)
+		r.AddCookie(cookie)
+
+		return r
+	}
+
+	expired := &Session{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute), RefreshToken: "old"}
+
+	assert.Nil(s.Session(request(expired)))
+
+	session, cookie, err := s.Refresh(context.TODO(), request(expired))
+
+	assert.Nil(err)
+	assert.Equal("alice", session.Subject)
+	assert.Equal("refresh", session.RefreshToken)
+	assert.Equal("session", cookie.Name)
+
+	_, _, err = s.Refresh(context.TODO(), request(&Session{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute)}))
+	assert.ErrorIs(err, ErrNoRefreshToken)
+
+	_, _, err = s.Refresh(context.TODO(), request(&Session{Subject: "bob", ExpiresAt: time.Now().Add(-time.Minute), RefreshToken: "old"}))
+	assert.NotNil(err, "refreshed sessions keep their subject")
+}
+
+func TestConcurrentRefreshesRedeemTheTokenOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	hydra := new(fakeHydra)
+	s := newTestService(t, hydra)
+
+	cookie, _ := s.SessionCookie(&Session{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute), RefreshToken: "old"})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(cookie)
+
+			session, refreshed, err := s.Refresh(context.TODO(), r)
+
+			assert.Nil(err)
+			assert.Equal("alice", session.Subject)
+			assert.Equal("session", refreshed.Name)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(int32(1), hydra.refreshes.Load(), "the rotated refresh token is only redeemed once")
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package resourcemetadata
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+)
+
+// WellKnownPath serves the metadata of the host root, the metadata of a resource is served
+// under it
+const WellKnownPath = "/.well-known/oauth-protected-resource"
+
+type API struct {
+	// issuer is the authorization server of the introspected access tokens
+	issuer string
+	// jwtIssuers are the issuers trusted by the jwt authenticator
+	jwtIssuers []string
+	policies   PoliciesInterface
+
+	logger logging.LoggerInterface
+}
+
+// RegisterEndpoints serves the metadata of the host root and, as in RFC 9728 section 3.1, of
+// the resources with a path component
+func (a *API) RegisterEndpoints(mux *chi.Mux) {
+	mux.Get(WellKnownPath, a.metadata)
+	mux.Get(WellKnownPath+"/*", a.metadata)
+}
+
+func (a *API) metadata(w http.ResponseWriter, r *http.Request) {
+	// the host only selects the policies, the resource is built from the configured host so
+	// that documents cached as public never reflect client headers
+	m := Build(a.policies.Policies(), a.issuer, a.jwtIssuers, r.Host, path.Clean("/"+chi.URLParam(r, "*")))
+
+	if m == nil {
+		http.Error(w, "no protected resource", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+func NewAPI(issuer string, jwtIssuers []string, policies PoliciesInterface, logger logging.LoggerInterface) *API {
+	a := new(API)
+
+	a.issuer = issuer
+	a.jwtIssuers = jwtIssuers
+	a.policies = policies
+
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package resourcemetadata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+func TestMetadata(t *testing.T) {
+	set := &policy.Set{
+		Policies: []policy.Policy{
+			{Name: "orders-write", Match: policy.Match{Hosts: []string{"api.example.com"}, PathPrefix: "/orders", Methods: []string{"POST"}}, Authenticators: []string{policy.AuthenticatorJWT}, Scopes: []string{"orders:write"}, RequireDPoP: true},
+			{Name: "orders", Match: policy.Match{Hosts: []string{"api.example.com"}, PathPrefix: "/orders"}, Authenticators: []string{policy.AuthenticatorIntrospection}, Scopes: []string{"orders:read"}},
+			{Name: "payments", Match: policy.Match{Hosts: []string{"api.example.com"}, PathPrefix: "/payments"}, Authenticators: []string{policy.AuthenticatorJWT}, Scopes: []string{"payments"}, RequireDPoP: true},
+			{Name: "partners", Match: policy.Match{Hosts: []string{"partners.example.com"}}, Authenticators: []string{policy.AuthenticatorJWT}, Issuers: []string{"https://idp.partner.com"}},
+			{Name: "health", Match: policy.Match{PathPrefix: "/healthz"}, Authenticators: []string{policy.AuthenticatorAnonymous}},
+			{Name: "catch all", Authenticators: []string{policy.AuthenticatorIntrospection}},
+		},
+	}
+
+	hydra := "https://hydra.example.com/"
+
+	tests := []struct {
+		name     string
+		path     string
+		host     string
+		status   int
+		resource string
+		servers  []string
+		scopes   []string
+		dpop     bool
+	}{
+		{name: "host", path: WellKnownPath, host: "api.example.com", status: http.StatusOK, resource: "https://api.example.com", servers: []string{hydra, "https://idp.partner.com"}, scopes: []string{"orders:read", "orders:write", "payments"}},
+		{name: "path", path: WellKnownPath + "/orders", host: "API.example.com", status: http.StatusOK, resource: "https://api.example.com/orders", servers: []string{hydra, "https://idp.partner.com"}, scopes: []string{"orders:read", "orders:write"}},
+		{name: "dpop only path", path: WellKnownPath + "/payments", host: "api.example.com:443", status: http.StatusOK, resource: "https://api.example.com/payments", servers: []string{hydra, "https://idp.partner.com"}, scopes: []string{"payments"}, dpop: true},
+		{name: "partner issuer only", path: WellKnownPath, host: "partners.example.com", status: http.StatusOK, resource: "https://partners.example.com", servers: []string{"https://idp.partner.com"}},
+		{name: "unprotected path", path: WellKnownPath + "/healthz", host: "api.example.com", status: http.StatusNotFound},
+		{name: "host not configured", path: WellKnownPath, host: "www.example.com", status: http.StatusNotFound},
+	}
+
+	mux := chi.NewMux()
+	NewAPI(hydra, []string{hydra, "https://idp.partner.com"}, policy.NewStore(set, "test"), logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Host = test.host
+			r.Header.Set("X-Forwarded-Host", "evil.example.com")
+			r.Header.Set("X-Forwarded-Proto", "http")
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			assert.Equal(test.status, w.Code)
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			m := new(Metadata)
+
+			assert.Nil(json.NewDecoder(w.Body).Decode(m))
+			assert.Equal(test.resource, m.Resource)
+			assert.Equal(test.servers, m.AuthorizationServers)
+			assert.Equal(test.scopes, m.ScopesSupported)
+			assert.Equal([]string{"header"}, m.BearerMethodsSupported)
+			assert.Contains(m.DPoPSigningAlgValuesSupported, "ES256")
+			assert.Equal(test.dpop, m.DPoPBoundAccessTokensRequired)
+		})
+	}
+}
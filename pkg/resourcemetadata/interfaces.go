@@ -0,0 +1,12 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package resourcemetadata
+
+import (
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+type PoliciesInterface interface {
+	Policies() *policy.Set
+}
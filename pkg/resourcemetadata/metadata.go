@@ -0,0 +1,131 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package resourcemetadata
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/dpop"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// Metadata is the OAuth 2.0 protected resource metadata document of RFC 9728
+type Metadata struct {
+	Resource                      string   `json:"resource"`
+	AuthorizationServers          []string `json:"authorization_servers"`
+	ScopesSupported               []string `json:"scopes_supported,omitempty"`
+	BearerMethodsSupported        []string `json:"bearer_methods_supported"`
+	DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported"`
+	DPoPBoundAccessTokensRequired bool     `json:"dpop_bound_access_tokens_required"`
+}
+
+// Build generates the metadata of the resource served at host under path from the policies
+// accepting access tokens on it, nil when no policy does; only policies listing the host are
+// used and the resource is built from the configured host, never from the request. Issuer is
+// the authorization server of introspected tokens, jwtIssuers the issuers trusted by the jwt
+// authenticator
+func Build(set *policy.Set, issuer string, jwtIssuers []string, host, path string) *Metadata {
+	scopes := make([]string, 0)
+	servers := make([]string, 0)
+	resourceHost := ""
+	protected, dpopOnly := 0, 0
+
+	for _, p := range set.Policies {
+		configured, ok := configuredHost(&p, host)
+
+		if !ok || !covers(&p, path) {
+			continue
+		}
+
+		issuers := authorizationServers(&p, issuer, jwtIssuers)
+
+		if len(issuers) == 0 {
+			continue
+		}
+
+		if resourceHost == "" {
+			resourceHost = configured
+		}
+
+		protected++
+
+		for _, s := range issuers {
+			if !slices.Contains(servers, s) {
+				servers = append(servers, s)
+			}
+		}
+
+		if p.RequireDPoP {
+			dpopOnly++
+		}
+
+		for _, scope := range p.Scopes {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	if protected == 0 {
+		return nil
+	}
+
+	slices.Sort(scopes)
+
+	m := new(Metadata)
+	m.Resource = "https://" + resourceHost + strings.TrimSuffix(path, "/")
+	m.AuthorizationServers = servers
+	m.ScopesSupported = scopes
+	m.BearerMethodsSupported = []string{"header"}
+	m.DPoPSigningAlgValuesSupported = strings.Fields(dpop.AlgorithmsChallenge())
+	m.DPoPBoundAccessTokensRequired = dpopOnly == protected
+
+	return m
+}
+
+// configuredHost returns the host of the policy the request host matches, policies matching
+// any host don't describe a resource
+func configuredHost(p *policy.Policy, host string) (string, bool) {
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host = host[:i]
+	}
+
+	for _, h := range p.Match.Hosts {
+		if strings.EqualFold(h, host) {
+			return h, true
+		}
+	}
+
+	return "", false
+}
+
+// covers tells if the policy applies to the resource, either to the whole of it or to one of
+// its sub paths
+func covers(p *policy.Policy, path string) bool {
+	return p.Match.MatchesPath(path) || strings.HasPrefix(p.Match.PathPrefix, path)
+}
+
+// authorizationServers returns the issuers of the access tokens accepted by the policy, the
+// issuer for introspected tokens and the trusted issuers allowed on the route for JWTs
+func authorizationServers(p *policy.Policy, issuer string, jwtIssuers []string) []string {
+	servers := make([]string, 0)
+
+	for _, name := range p.Chain() {
+		switch name {
+		case policy.AuthenticatorIntrospection:
+			if issuer != "" {
+				servers = append(servers, issuer)
+			}
+		case policy.AuthenticatorJWT:
+			for _, i := range jwtIssuers {
+				if len(p.Issuers) == 0 || slices.Contains(p.Issuers, i) {
+					servers = append(servers, i)
+				}
+			}
+		}
+	}
+
+	return servers
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package serviceaccount
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UsernamePrefix starts the kubernetes username of every service account
+const UsernamePrefix = "system:serviceaccount:"
+
+var (
+	// ErrInvalidToken wraps the rejections of service account tokens
+	ErrInvalidToken = errors.New("invalid service account token")
+	// ErrUnavailable wraps the failures to reach the API server or its key set
+	ErrUnavailable = errors.New("kubernetes API unavailable")
+)
+
+// Account is the service account a token was issued to
+type Account struct {
+	// Username is system:serviceaccount:<namespace>:<name>
+	Username  string
+	Namespace string
+	Name      string
+	UID       string
+	Audiences []string
+}
+
+// ParseUsername splits a service account username in namespace and name
+func ParseUsername(username string) (*Account, error) {
+	parts := strings.Split(strings.TrimPrefix(username, UsernamePrefix), ":")
+
+	if !strings.HasPrefix(username, UsernamePrefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%w: %q is not a service account", ErrInvalidToken, username)
+	}
+
+	a := new(Account)
+	a.Username = username
+	a.Namespace = parts[0]
+	a.Name = parts[1]
+
+	return a, nil
+}
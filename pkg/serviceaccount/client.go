@@ -0,0 +1,64 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package serviceaccount
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// InClusterTokenFile and InClusterCAFile are mounted in every pod with a service account
+	InClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	InClusterCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// tokenTransport authenticates requests to the API server with the service account token of
+// the authorizer, read on every request as projected tokens are rotated by the kubelet
+type tokenTransport struct {
+	tokenFile string
+	base      http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := os.ReadFile(t.tokenFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account token: %w", err)
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	return t.base.RoundTrip(r)
+}
+
+// NewClient returns an HTTP client trusting the cluster CA and authenticated with the token
+// in tokenFile, an empty caFile keeps the system roots
+func NewClient(caFile, tokenFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cluster CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &http.Client{Transport: &tokenTransport{tokenFile: tokenFile, base: transport}, Timeout: 10 * time.Second}, nil
+}
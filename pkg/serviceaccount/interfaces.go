@@ -0,0 +1,15 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package serviceaccount
+
+import (
+	"context"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+)
+
+type JWTVerifierInterface interface {
+	Issuer() string
+	Verify(context.Context, string) (*oidc.Token, error)
+}
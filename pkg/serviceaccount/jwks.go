@@ -0,0 +1,76 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package serviceaccount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+)
+
+// JWKSValidator validates service account tokens locally against the key set published by the
+// API server through service account issuer discovery
+type JWKSValidator struct {
+	verifier JWTVerifierInterface
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+func (v *JWKSValidator) Issuer() string {
+	return v.verifier.Issuer()
+}
+
+func (v *JWKSValidator) Validate(ctx context.Context, raw string) (*Account, error) {
+	ctx, span := v.tracer.Start(ctx, "serviceaccount.JWKSValidator.Validate")
+	defer span.End()
+
+	token, err := v.verifier.Verify(ctx, raw)
+
+	if errors.Is(err, oidc.ErrKeysUnavailable) {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	account, err := ParseUsername(token.Subject)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the kubernetes.io claim must agree with the subject
+	k8s, _ := token.Claims["kubernetes.io"].(map[string]interface{})
+	sa, _ := k8s["serviceaccount"].(map[string]interface{})
+
+	if namespace, _ := k8s["namespace"].(string); namespace != account.Namespace {
+		return nil, fmt.Errorf("%w: namespace claim %q doesn't match the subject", ErrInvalidToken, namespace)
+	}
+
+	if name, _ := sa["name"].(string); name != account.Name {
+		return nil, fmt.Errorf("%w: service account claim %q doesn't match the subject", ErrInvalidToken, name)
+	}
+
+	account.UID, _ = sa["uid"].(string)
+	account.Audiences = token.Audience
+
+	return account, nil
+}
+
+func NewJWKSValidator(verifier JWTVerifierInterface, tracer tracing.TracingInterface, logger logging.LoggerInterface) *JWKSValidator {
+	v := new(JWKSValidator)
+
+	v.verifier = verifier
+
+	v.tracer = tracer
+	v.logger = logger
+
+	return v
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package serviceaccount
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const tokenReviewPath = "/apis/authentication.k8s.io/v1/tokenreviews"
+
+// TokenReview is the subset of the authentication.k8s.io/v1 TokenReview used by the validator
+type TokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       TokenReviewSpec   `json:"spec"`
+	Status     TokenReviewStatus `json:"status,omitempty"`
+}
+
+type TokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type TokenReviewStatus struct {
+	Authenticated bool     `json:"authenticated"`
+	User          UserInfo `json:"user,omitempty"`
+	Audiences     []string `json:"audiences,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+type UserInfo struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// TokenReviewer validates service account tokens through the TokenReview API, revoked tokens
+// and deleted pods are caught at the price of a call to the API server
+type TokenReviewer struct {
+	url       string
+	issuer    string
+	audiences []string
+
+	client *http.Client
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+func (t *TokenReviewer) Issuer() string {
+	return t.issuer
+}
+
+func (t *TokenReviewer) Validate(ctx context.Context, raw string) (*Account, error) {
+	ctx, span := t.tracer.Start(ctx, "serviceaccount.TokenReviewer.Validate")
+	defer span.End()
+
+	review := TokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       TokenReviewSpec{Token: raw, Audiences: t.audiences},
+	}
+
+	body, err := json.Marshal(review)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url+tokenReviewPath, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: token review status %d", ErrUnavailable, resp.StatusCode)
+	case resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK:
+		// the authorizer itself isn't allowed to review tokens
+		return nil, fmt.Errorf("token review failed with status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return nil, fmt.Errorf("invalid token review: %w", err)
+	}
+
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, review.Status.Error)
+	}
+
+	if len(t.audiences) > 0 && !slices.ContainsFunc(review.Status.Audiences, func(aud string) bool { return slices.Contains(t.audiences, aud) }) {
+		return nil, fmt.Errorf("%w: audiences %s not allowed", ErrInvalidToken, strings.Join(review.Status.Audiences, ", "))
+	}
+
+	account, err := ParseUsername(review.Status.User.Username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	account.UID = review.Status.User.UID
+	account.Audiences = review.Status.Audiences
+
+	return account, nil
+}
+
+func NewTokenReviewer(url, issuer string, audiences []string, client *http.Client, tracer tracing.TracingInterface, logger logging.LoggerInterface) *TokenReviewer {
+	t := new(TokenReviewer)
+
+	t.url = strings.TrimSuffix(url, "/")
+	t.issuer = issuer
+	t.audiences = audiences
+	t.client = client
+
+	t.tracer = tracer
+	t.logger = logger
+
+	return t
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package serviceaccount
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+)
+
+const (
+	testIssuer      = "https://kubernetes.default.svc.cluster.local"
+	authorizerToken = "authorizer-token"
+)
+
+// fakeAPIServer serves the key set of the service account issuer and token reviews of the
+// tokens it signed, requests must carry the token of the authorizer
+type fakeAPIServer struct {
+	*httptest.Server
+
+	key     *ecdsa.PrivateKey
+	reviews int
+}
+
+func newFakeAPIServer(t *testing.T) *fakeAPIServer {
+	s := new(fakeAPIServer)
+	s.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/openid/v1/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+authorizerToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: s.key.Public(), KeyID: "sa", Algorithm: string(jose.ES256), Use: "sig"}}})
+	})
+
+	mux.HandleFunc(tokenReviewPath, func(w http.ResponseWriter, r *http.Request) {
+		s.reviews++
+
+		if r.Header.Get("Authorization") != "Bearer "+authorizerToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		review := new(TokenReview)
+		_ = json.NewDecoder(r.Body).Decode(review)
+
+		parsed, err := jwt.ParseSigned(review.Spec.Token, oidc.SignatureAlgorithms)
+		claims := jwt.Claims{}
+
+		if err == nil {
+			err = parsed.Claims(s.key.Public(), &claims)
+		}
+
+		if err == nil {
+			err = claims.Validate(jwt.Expected{Issuer: testIssuer, AnyAudience: review.Spec.Audiences, Time: time.Now()})
+		}
+
+		if err != nil {
+			review.Status = TokenReviewStatus{Error: err.Error()}
+		} else {
+			review.Status = TokenReviewStatus{Authenticated: true, User: UserInfo{Username: claims.Subject, UID: "uid-1"}, Audiences: claims.Audience}
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(review)
+	})
+
+	s.Server = httptest.NewTLSServer(mux)
+
+	return s
+}
+
+func (s *fakeAPIServer) token(t *testing.T, subject, namespace, name string, audience string, expiry time.Time) string {
+	signer, _ := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: s.key},
+		(&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), "sa"),
+	)
+
+	raw, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   testIssuer,
+		Subject:  subject,
+		Audience: jwt.Audience{audience},
+		Expiry:   jwt.NewNumericDate(expiry),
+	}).Claims(map[string]interface{}{
+		"kubernetes.io": map[string]interface{}{
+			"namespace":      namespace,
+			"serviceaccount": map[string]interface{}{"name": name, "uid": "uid-1"},
+		},
+	}).Serialize()
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return raw
+}
+
+// client writes the CA of the fake API server and the token of the authorizer to disk, as
+// mounted in pods
+func (s *fakeAPIServer) client(t *testing.T) *http.Client {
+	dir := t.TempDir()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	tokenFile := filepath.Join(dir, "token")
+
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o600)
+	_ = os.WriteFile(tokenFile, []byte(authorizerToken+"\n"), 0o600)
+
+	client, err := NewClient(caFile, tokenFile)
+
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return client
+}
+
+func TestValidators(t *testing.T) {
+	server := newFakeAPIServer(t)
+	defer server.Close()
+
+	tracer := tracing.NewNoopTracer()
+	logger := logging.NewNoopLogger()
+	client := server.client(t)
+
+	validators := map[string]interface {
+		Validate(context.Context, string) (*Account, error)
+	}{
+		"jwks": NewJWKSValidator(
+			oidc.NewVerifier(testIssuer, []string{"orders"}, oidc.NewKeySet(server.URL+"/openid/v1/jwks", client, tracer, logger), tracer, logger),
+			tracer, logger,
+		),
+		"tokenreview": NewTokenReviewer(server.URL, testIssuer, []string{"orders"}, client, tracer, logger),
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		err     error
+		account *Account
+	}{
+		{
+			name:    "valid",
+			token:   server.token(t, "system:serviceaccount:shop:checkout", "shop", "checkout", "orders", time.Now().Add(time.Hour)),
+			account: &Account{Username: "system:serviceaccount:shop:checkout", Namespace: "shop", Name: "checkout", UID: "uid-1", Audiences: []string{"orders"}},
+		},
+		{name: "expired", token: server.token(t, "system:serviceaccount:shop:checkout", "shop", "checkout", "orders", time.Now().Add(-time.Hour)), err: ErrInvalidToken},
+		{name: "wrong audience", token: server.token(t, "system:serviceaccount:shop:checkout", "shop", "checkout", "payments", time.Now().Add(time.Hour)), err: ErrInvalidToken},
+		{name: "not a service account", token: server.token(t, "alice", "shop", "checkout", "orders", time.Now().Add(time.Hour)), err: ErrInvalidToken},
+	}
+
+	for mode, validator := range validators {
+		for _, test := range tests {
+			t.Run(mode+" "+test.name, func(t *testing.T) {
+				account, err := validator.Validate(context.Background(), test.token)
+
+				assert.True(t, errors.Is(err, test.err), "unexpected error %v", err)
+				assert.Equal(t, test.account, account)
+			})
+		}
+	}
+
+	assert.Equal(t, len(tests), server.reviews)
+}
+
+func TestValidatorsUnavailable(t *testing.T) {
+	server := newFakeAPIServer(t)
+	client := server.client(t)
+	token := server.token(t, "system:serviceaccount:shop:checkout", "shop", "checkout", "orders", time.Now().Add(time.Hour))
+	server.Close()
+
+	tracer := tracing.NewNoopTracer()
+	logger := logging.NewNoopLogger()
+
+	_, err := NewTokenReviewer(server.URL, testIssuer, nil, client, tracer, logger).Validate(context.Background(), token)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+
+	_, err = NewJWKSValidator(
+		oidc.NewVerifier(testIssuer, nil, oidc.NewKeySet(server.URL+"/openid/v1/jwks", client, tracer, logger), tracer, logger),
+		tracer, logger,
+	).Validate(context.Background(), token)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+}
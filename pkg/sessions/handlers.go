@@ -0,0 +1,113 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package sessions
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+// EvictionRequest is the payload accepted by the eviction webhook, it is meant to be
+// produced by a kratos after-logout web_hook or by admin tooling, at least one field is needed
+type EvictionRequest struct {
+	SessionID  string `json:"session_id"`
+	IdentityID string `json:"identity_id"`
+	Token      string `json:"token"`
+}
+
+type API struct {
+	secret string
+
+	evictor EvictorInterface
+
+	tracer tracing.TracingInterface
+	logger logging.LoggerInterface
+}
+
+func (a *API) RegisterEndpoints(mux *chi.Mux) {
+	if a.secret == "" {
+		a.logger.Warn("session eviction webhook secret not set, webhook endpoint disabled")
+		return
+	}
+
+	mux.Post("/api/v0/sessions/evict", a.evict)
+}
+
+func (a *API) evict(w http.ResponseWriter, r *http.Request) {
+	if !a.authenticated(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx, span := a.tracer.Start(r.Context(), "sessions.API.evict")
+	defer span.End()
+
+	req := new(EvictionRequest)
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "invalid eviction payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionID == "" && req.IdentityID == "" && req.Token == "" {
+		http.Error(w, "one of session_id, identity_id or token is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionID != "" {
+		if err := a.evictor.EvictSession(ctx, req.SessionID); err != nil {
+			a.logger.Errorf("error evicting session %s: %v", req.SessionID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.IdentityID != "" {
+		if err := a.evictor.EvictIdentity(ctx, req.IdentityID); err != nil {
+			a.logger.Errorf("error evicting sessions of identity %s: %v", req.IdentityID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Token != "" {
+		if err := a.evictor.EvictToken(ctx, req.Token); err != nil {
+			a.logger.Errorf("error evicting session token: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	a.logger.Infof("evicted cached sessions, session: %s identity: %s", req.SessionID, req.IdentityID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) authenticated(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !found {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(a.secret)) == 1
+}
+
+func NewAPI(secret string, evictor EvictorInterface, tracer tracing.TracingInterface, logger logging.LoggerInterface) *API {
+	a := new(API)
+
+	a.secret = secret
+	a.evictor = evictor
+
+	a.tracer = tracer
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package sessions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+type fakeEvictor struct {
+	sessions   []string
+	identities []string
+	tokens     []string
+
+	err error
+}
+
+func (f *fakeEvictor) EvictSession(ctx context.Context, id string) error {
+	f.sessions = append(f.sessions, id)
+
+	return f.err
+}
+
+func (f *fakeEvictor) EvictIdentity(ctx context.Context, id string) error {
+	f.identities = append(f.identities, id)
+
+	return f.err
+}
+
+func (f *fakeEvictor) EvictToken(ctx context.Context, token string) error {
+	f.tokens = append(f.tokens, token)
+
+	return f.err
+}
+
+func newTestRouter(secret string, evictor EvictorInterface) *chi.Mux {
+	mux := chi.NewMux()
+
+	NewAPI(secret, evictor, tracing.NewNoopTracer(), logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+	return mux
+}
+
+func evict(mux *chi.Mux, authorization, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v0/sessions/evict", strings.NewReader(body))
+
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestEvict(t *testing.T) {
+	assert := assert.New(t)
+
+	evictor := new(fakeEvictor)
+	mux := newTestRouter("secret", evictor)
+
+	w := evict(mux, "Bearer secret", `{"session_id":"s1","identity_id":"i1","token":"t1"}`)
+
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Equal([]string{"s1"}, evictor.sessions)
+	assert.Equal([]string{"i1"}, evictor.identities)
+	assert.Equal([]string{"t1"}, evictor.tokens)
+}
+
+func TestEvictRejected(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		body          string
+		status        int
+	}{
+		{"no secret", "", `{"session_id":"s1"}`, http.StatusUnauthorized},
+		{"wrong secret", "Bearer other", `{"session_id":"s1"}`, http.StatusUnauthorized},
+		{"wrong scheme", "Basic secret", `{"session_id":"s1"}`, http.StatusUnauthorized},
+		{"invalid payload", "Bearer secret", `{`, http.StatusBadRequest},
+		{"empty payload", "Bearer secret", `{}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evictor := new(fakeEvictor)
+
+			w := evict(newTestRouter("secret", evictor), test.authorization, test.body)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Empty(t, evictor.sessions)
+		})
+	}
+}
+
+func TestEvictFailure(t *testing.T) {
+	evictor := &fakeEvictor{err: errors.New("cache down")}
+
+	w := evict(newTestRouter("secret", evictor), "Bearer secret", `{"identity_id":"i1"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestEvictDisabledWithoutSecret(t *testing.T) {
+	w := evict(newTestRouter("", new(fakeEvictor)), "Bearer ", `{"session_id":"s1"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,14 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package sessions
+
+import (
+	"context"
+)
+
+type EvictorInterface interface {
+	EvictSession(context.Context, string) error
+	EvictIdentity(context.Context, string) error
+	EvictToken(context.Context, string) error
+}
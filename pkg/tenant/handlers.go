@@ -0,0 +1,107 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+)
+
+// HostFunc returns the host the request was sent to
+type HostFunc func(*http.Request) string
+
+// HandlerFactory builds the handler serving the tenant scoped routes of a tenant
+type HandlerFactory func(*Tenant) (http.Handler, error)
+
+// API dispatches the tenant scoped routes to the handler of the tenant the request belongs to,
+// handlers and their clients are built on the first request of each tenant
+type API struct {
+	table  *Table
+	routes []string
+	host   HostFunc
+
+	fallback http.Handler
+	factory  HandlerFactory
+
+	handlers map[string]http.Handler
+	mu       sync.Mutex
+
+	logger logging.LoggerInterface
+}
+
+func (a *API) RegisterEndpoints(mux *chi.Mux) {
+	for _, route := range a.routes {
+		mux.Handle(route, a)
+	}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant := a.table.Resolve(r, a.host(r))
+
+	if tenant == nil {
+		a.fallback.ServeHTTP(w, isolated(r))
+		return
+	}
+
+	monitoring.SetLabel(r.Context(), monitoring.TenantLabel, tenant.Name)
+
+	handler, err := a.handler(tenant)
+
+	if err != nil {
+		a.logger.Errorf("unable to set up tenant %s: %v", tenant.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	handler.ServeHTTP(w, isolated(r))
+}
+
+// isolated gives the tenant router its own routing context, so that the routes it matches are
+// not appended to the route pattern of the outer router used as metric label
+func isolated(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, chi.NewRouteContext()))
+}
+
+// handler returns the handler of the tenant, failures are not cached so that a broken tenant
+// configuration is retried on the next request
+func (a *API) handler(tenant *Tenant) (http.Handler, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if handler, ok := a.handlers[tenant.Name]; ok {
+		return handler, nil
+	}
+
+	handler, err := a.factory(tenant)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.logger.Infof("tenant %s set up", tenant.Name)
+	a.handlers[tenant.Name] = handler
+
+	return handler, nil
+}
+
+func NewAPI(table *Table, routes []string, host HostFunc, fallback http.Handler, factory HandlerFactory, logger logging.LoggerInterface) *API {
+	a := new(API)
+
+	a.table = table
+	a.routes = routes
+	a.host = host
+	a.fallback = fallback
+	a.factory = factory
+	a.handlers = make(map[string]http.Handler)
+
+	a.logger = logger
+
+	return a
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tenant
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+)
+
+func TestDispatchesByTenant(t *testing.T) {
+	assert := assert.New(t)
+
+	table := &Table{
+		Header:         "x-tenant",
+		HeaderNetworks: []string{"10.0.0.0/8"},
+		Tenants: []Tenant{
+			{Name: "acme", Hosts: []string{"*.acme.example.com"}, KratosPublicURL: "http://kratos.acme", HydraAdminURL: "http://hydra.acme", PoliciesFile: "acme.yaml"},
+			{Name: "globex", Hosts: []string{"globex.example.com"}, KratosPublicURL: "http://kratos.globex", HydraAdminURL: "http://hydra.globex", PoliciesFile: "globex.yaml"},
+		},
+	}
+
+	assert.Nil(table.Validate())
+
+	built := make(map[string]int)
+
+	factory := func(tenant *Tenant) (http.Handler, error) {
+		built[tenant.Name]++
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprint(w, tenant.Name)
+		}), nil
+	}
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "default")
+	})
+
+	mux := chi.NewMux()
+	// tenants are picked by the host of the request, never by client sent forwarded headers
+	host := func(r *http.Request) string { return r.Host }
+
+	NewAPI(table, []string{"/api/v0/check"}, host, fallback, factory, logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+	tests := []struct {
+		host     string
+		header   string
+		peer     string
+		expected string
+	}{
+		{"app.acme.example.com:443", "", "", "acme"},
+		{"acme.example.com", "", "", "default"},
+		{"globex.example.com", "", "", "globex"},
+		{"app.acme.example.com", "globex", "10.0.0.7:5000", "globex"},
+		// the tenant header sent by an untrusted peer is ignored
+		{"app.acme.example.com", "globex", "203.0.113.7:5000", "acme"},
+		{"other.example.com", "", "", "default"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/v0/check", nil)
+		r.Host = test.host
+
+		if test.peer != "" {
+			r.RemoteAddr = test.peer
+		}
+		r.Header.Set("X-Forwarded-Host", "globex.example.com")
+
+		if test.header != "" {
+			r.Header.Set("x-tenant", test.header)
+		}
+
+		ctx, labels := monitoring.ContextWithLabels(r.Context())
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r.WithContext(ctx))
+
+		assert.Equal(test.expected, w.Body.String(), test.host)
+
+		if test.expected != "default" {
+			assert.Equal(test.expected, labels.Get(monitoring.TenantLabel))
+		}
+	}
+
+	assert.Equal(1, built["acme"])
+	assert.Equal(1, built["globex"])
+}
+
+func TestTenantRoutesKeepOuterRoutePattern(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := chi.NewMux()
+	inner.Get("/api/v0/check/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	factory := func(*Tenant) (http.Handler, error) { return inner, nil }
+	table := &Table{Tenants: []Tenant{{Name: "acme", Hosts: []string{"acme.example.com"}}}}
+
+	pattern := ""
+
+	mux := chi.NewMux()
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			pattern = chi.RouteContext(r.Context()).RoutePattern()
+		})
+	})
+
+	host := func(r *http.Request) string { return r.Host }
+
+	NewAPI(table, []string{"/api/v0/check/*"}, host, inner, factory, logging.NewNoopLogger()).RegisterEndpoints(mux)
+
+	for _, h := range []string{"acme.example.com", "other.example.com"} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v0/check/orders/1", nil)
+		r.Host = h
+
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Equal("/api/v0/check/*", pattern, h)
+	}
+}
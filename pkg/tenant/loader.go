@@ -0,0 +1,171 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tenant
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+)
+
+// Load reads the tenant table from a YAML (or JSON) file, an empty path returns nil
+func Load(path string) (*Table, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tenants: %w", err)
+	}
+
+	t := new(Table)
+
+	if err := yaml.Unmarshal(raw, t); err != nil {
+		return nil, fmt.Errorf("unable to parse tenants: %w", err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// issuerBound lists the authenticators tied to the issuers of the default backends, they are
+// never available to tenants
+var issuerBound = []string{policy.AuthenticatorJWT, policy.AuthenticatorRelyingParty, policy.AuthenticatorAPIKey}
+
+// LoadPolicies reads the policies of the tenant, policies relying on the JWT issuers, relying
+// party, API keys or token exchange of the default backends are refused
+func (t *Tenant) LoadPolicies() (*policy.Set, error) {
+	s, err := policy.Load(t.PoliciesFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range s.Policies {
+		if p.RelyingParty || p.TokenExchange != nil {
+			return nil, fmt.Errorf("tenant %s: policy %s: relying party and token exchange are not available to tenants", t.Name, p.Name)
+		}
+
+		for _, name := range p.Authenticators {
+			if slices.Contains(issuerBound, name) {
+				return nil, fmt.Errorf("tenant %s: policy %s: authenticator %s is not available to tenants", t.Name, p.Name, name)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// LoadStores loads the policy stores of the tenants by name, shared by the tenant routes and the
+// admin API; policies are loaded at startup so that a broken tenant fails fast
+func (t *Table) LoadStores() (map[string]*policy.Store, error) {
+	stores := make(map[string]*policy.Store)
+
+	if t == nil {
+		return stores, nil
+	}
+
+	for i := range t.Tenants {
+		tenant := &t.Tenants[i]
+
+		set, err := tenant.LoadPolicies()
+
+		if err != nil {
+			return nil, err
+		}
+
+		stores[tenant.Name] = policy.NewStore(set, tenant.PoliciesFile)
+	}
+
+	return stores, nil
+}
+
+// Validate checks the tenant table for inconsistencies
+func (t *Table) Validate() error {
+	if t.Header != "" && len(t.HeaderNetworks) == 0 {
+		return fmt.Errorf("header_networks are required with the tenant header")
+	}
+
+	t.networks = make([]*net.IPNet, 0, len(t.HeaderNetworks))
+
+	for _, n := range t.HeaderNetworks {
+		network, err := parseNetwork(n)
+
+		if err != nil {
+			return err
+		}
+
+		t.networks = append(t.networks, network)
+	}
+
+	names := make(map[string]bool)
+
+	for _, tenant := range t.Tenants {
+		if tenant.Name == "" {
+			return fmt.Errorf("tenant name is required")
+		}
+
+		if names[tenant.Name] {
+			return fmt.Errorf("duplicate tenant %s", tenant.Name)
+		}
+
+		names[tenant.Name] = true
+
+		if tenant.KratosPublicURL == "" || tenant.HydraAdminURL == "" {
+			return fmt.Errorf("tenant %s: kratos_public_url and hydra_admin_url are required", tenant.Name)
+		}
+
+		// an empty policy set would fall back to the default chain on every route
+		if tenant.PoliciesFile == "" {
+			return fmt.Errorf("tenant %s: policies_file is required", tenant.Name)
+		}
+
+		if len(tenant.Hosts) == 0 && t.Header == "" {
+			return fmt.Errorf("tenant %s: hosts are required when no tenant header is set", tenant.Name)
+		}
+
+		for header, attribute := range tenant.IdentityHeaders {
+			switch {
+			case attribute == "subject", attribute == "username", attribute == "client_id", attribute == "groups":
+			case strings.HasPrefix(attribute, "claims.") && len(attribute) > len("claims."):
+			default:
+				return fmt.Errorf("tenant %s: unknown identity attribute %q for header %s", tenant.Name, attribute, header)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseNetwork parses a CIDR, plain IPs are accepted as single host networks
+func parseNetwork(n string) (*net.IPNet, error) {
+	n = strings.TrimSpace(n)
+
+	if !strings.Contains(n, "/") {
+		if ip := net.ParseIP(n); ip != nil && ip.To4() != nil {
+			n = n + "/32"
+		} else {
+			n = n + "/128"
+		}
+	}
+
+	_, network, err := net.ParseCIDR(n)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant header network %q: %w", n, err)
+	}
+
+	return network, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRequiresPolicies(t *testing.T) {
+	table := &Table{Tenants: []Tenant{{Name: "acme", Hosts: []string{"acme.example.com"}, KratosPublicURL: "http://kratos", HydraAdminURL: "http://hydra"}}}
+
+	assert.NotNil(t, table.Validate())
+
+	table.Tenants[0].PoliciesFile = "/etc/acme-policies.yaml"
+
+	assert.Nil(t, table.Validate())
+}
+
+func TestLoadPoliciesRefusesIssuerBoundFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies string
+		err      bool
+	}{
+		{"kratos", "policies:\n  - name: ui\n    authenticators: [kratos_cookie]\n", false},
+		{"jwt", "policies:\n  - name: api\n    authenticators: [jwt]\n", true},
+		{"api key", "policies:\n  - name: api\n    authenticators: [introspection, api_key]\n", true},
+		{"relying party", "policies:\n  - name: ui\n    relying_party: true\n", true},
+		{"token exchange", "policies:\n  - name: api\n    token_exchange:\n      audience: orders\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policies.yaml")
+			_ = os.WriteFile(path, []byte(test.policies), 0o600)
+
+			_, err := (&Tenant{Name: "acme", PoliciesFile: path}).LoadPolicies()
+
+			assert.Equal(t, test.err, err != nil, err)
+		})
+	}
+}
+
+func TestLoadStores(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	_ = os.WriteFile(path, []byte("policies:\n  - name: ui\n    authenticators: [kratos_cookie]\n"), 0o600)
+
+	stores, err := (&Table{Tenants: []Tenant{{Name: "acme", PoliciesFile: path}}}).LoadStores()
+
+	assert.Nil(err)
+	assert.Equal("ui", stores["acme"].Policies().Policies[0].Name)
+
+	_, err = (&Table{Tenants: []Tenant{{Name: "acme", PoliciesFile: filepath.Join(t.TempDir(), "missing.yaml")}}}).LoadStores()
+
+	assert.NotNil(err, "broken tenants fail at startup")
+
+	stores, err = (*Table)(nil).LoadStores()
+
+	assert.Nil(err)
+	assert.Empty(stores)
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Table maps requests to tenants, by the Header value when configured and sent by a trusted
+// peer, by host otherwise
+type Table struct {
+	// Header carries the tenant name, it must be set by the gateway and never taken from clients
+	Header string `json:"header,omitempty" yaml:"header"`
+	// HeaderNetworks lists the networks of the peers, the gateways, allowed to set the Header
+	HeaderNetworks []string `json:"header_networks,omitempty" yaml:"header_networks"`
+	Tenants        []Tenant `json:"tenants" yaml:"tenants"`
+
+	// networks are the parsed HeaderNetworks, set by Validate
+	networks []*net.IPNet
+}
+
+// Tenant is an Ory project served behind the gateway
+type Tenant struct {
+	Name string `json:"name" yaml:"name"`
+	// Hosts served by the tenant, a leading *. matches any subdomain
+	Hosts           []string `json:"hosts,omitempty" yaml:"hosts"`
+	KratosPublicURL string   `json:"kratos_public_url" yaml:"kratos_public_url"`
+	HydraAdminURL   string   `json:"hydra_admin_url" yaml:"hydra_admin_url"`
+	LoginUIURL      string   `json:"login_ui_url,omitempty" yaml:"login_ui_url"`
+	ConsentUIURL    string   `json:"consent_ui_url,omitempty" yaml:"consent_ui_url"`
+	PoliciesFile    string   `json:"policies_file,omitempty" yaml:"policies_file"`
+	// Issuer is the authorization server listed in the protected resource metadata of the
+	// tenant, usually the public URL of its hydra, no metadata is served when empty
+	Issuer string `json:"issuer,omitempty" yaml:"issuer"`
+	// IdentityHeaders maps the upstream header names to identity attributes, defaults to
+	// the kubeflow-userid header
+	IdentityHeaders map[string]string `json:"identity_headers,omitempty" yaml:"identity_headers"`
+}
+
+// Resolve returns the tenant of the request served on host, nil if none matches
+func (t *Table) Resolve(r *http.Request, host string) *Tenant {
+	if t == nil {
+		return nil
+	}
+
+	if t.Header != "" && t.trusted(r) {
+		if name := r.Header.Get(t.Header); name != "" {
+			return t.find(func(tenant *Tenant) bool { return tenant.Name == name })
+		}
+	}
+
+	host = strings.ToLower(stripPort(host))
+
+	return t.find(func(tenant *Tenant) bool { return tenant.serves(host) })
+}
+
+// trusted tells if the peer calling the authorizer is allowed to set the tenant header, forwarded
+// client addresses are never used as they can be spoofed
+func (t *Table) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Table) find(match func(*Tenant) bool) *Tenant {
+	for i := range t.Tenants {
+		if match(&t.Tenants[i]) {
+			return &t.Tenants[i]
+		}
+	}
+
+	return nil
+}
+
+func (t *Tenant) serves(host string) bool {
+	for _, h := range t.Hosts {
+		h = strings.ToLower(h)
+
+		if suffix, found := strings.CutPrefix(h, "*."); found {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+
+			continue
+		}
+
+		if h == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stripPort(host string) string {
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		return host[:i]
+	}
+
+	return host
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+
+	cacheKeyPrefix = "exchange:"
+	// expiryLeeway avoids handing out tokens about to expire while in flight
+	expiryLeeway = 10 * time.Second
+)
+
+// TokenResponse is the RFC 8693 token exchange response
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope"`
+}
+
+type Service struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+
+	client *http.Client
+	cache  cache.CacheInterface
+
+	tracer  tracing.TracingInterface
+	monitor monitoring.MonitorInterface
+	logger  logging.LoggerInterface
+}
+
+// Exchange trades the subject token for one limited to the audience and scopes, results
+// are cached per subject token, audience and scopes until they expire
+func (s *Service) Exchange(ctx context.Context, subjectToken, audience string, scopes []string) (string, error) {
+	ctx, span := s.tracer.Start(ctx, "tokenexchange.Service.Exchange")
+	defer span.End()
+
+	scope := strings.Join(scopes, " ")
+	key := cacheKey(subjectToken, audience, scope)
+
+	cached, err := s.cache.Get(ctx, key)
+
+	if err == nil {
+		return string(cached), nil
+	}
+
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		logging.FromContext(ctx, s.logger).Errorf("error fetching exchanged token from cache: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", grantTypeTokenExchange)
+	form.Set("subject_token", subjectToken)
+	form.Set("subject_token_type", tokenTypeAccessToken)
+	form.Set("requested_token_type", tokenTypeAccessToken)
+
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return "", fmt.Errorf("token exchange request failed: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token exchange rejected with status %d", resp.StatusCode)
+	}
+
+	token := new(TokenResponse)
+
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return "", fmt.Errorf("invalid token exchange response: %w", err)
+	}
+
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token exchange response has no access_token")
+	}
+
+	if ttl := time.Duration(token.ExpiresIn)*time.Second - expiryLeeway; ttl > 0 {
+		if err := s.cache.Set(ctx, key, []byte(token.AccessToken), ttl); err != nil {
+			logging.FromContext(ctx, s.logger).Errorf("error caching exchanged token: %v", err)
+		}
+	}
+
+	return token.AccessToken, nil
+}
+
+// cacheKey hashes the subject token itself, subjects of different issuers can collide and
+// the exchanged token must never outlive or outgrow the one it was traded for
+func cacheKey(subjectToken, audience, scope string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{subjectToken, audience, scope}, "\x00")))
+
+	return cacheKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func NewService(
+	tokenURL, clientID, clientSecret string, c cache.CacheInterface,
+	tracer tracing.TracingInterface, monitor monitoring.MonitorInterface, logger logging.LoggerInterface,
+) *Service {
+	s := new(Service)
+
+	s.tokenURL = tokenURL
+	s.clientID = clientID
+	s.clientSecret = clientSecret
+
+	s.client = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: 10 * time.Second}
+	s.cache = c
+
+	s.tracer = tracer
+	s.monitor = monitor
+	s.logger = logger
+
+	return s
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package tokenexchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shipperizer/iam-ext-authz/internal/cache"
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+)
+
+func TestExchangeIsCached(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		assert.Nil(r.ParseForm())
+		assert.Equal(grantTypeTokenExchange, r.PostForm.Get("grant_type"))
+		assert.Equal("subject-token", r.PostForm.Get("subject_token"))
+		assert.Equal("orders", r.PostForm.Get("audience"))
+		assert.Equal("orders:read", r.PostForm.Get("scope"))
+
+		clientID, _, _ := r.BasicAuth()
+		assert.Equal("authorizer", clientID)
+
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "downscoped", ExpiresIn: 3600})
+	}))
+	defer srv.Close()
+
+	logger := logging.NewNoopLogger()
+	s := NewService(srv.URL, "authorizer", "secret", cache.NewMemory(), tracing.NewNoopTracer(), monitoring.NewNoopMonitor("test", logger), logger)
+
+	for i := 0; i < 2; i++ {
+		token, err := s.Exchange(context.TODO(), "subject-token", "orders", []string{"orders:read"})
+
+		assert.Nil(err)
+		assert.Equal("downscoped", token)
+	}
+
+	assert.Equal(1, calls, "second exchange should be served from cache")
+}
+
+func TestExchangeRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	logger := logging.NewNoopLogger()
+	s := NewService(srv.URL, "authorizer", "secret", cache.NewMemory(), tracing.NewNoopTracer(), monitoring.NewNoopMonitor("test", logger), logger)
+
+	_, err := s.Exchange(context.TODO(), "subject-token", "orders", nil)
+
+	assert.NotNil(t, err)
+}
+
+func TestExchangeCachedPerSubjectToken(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(r.ParseForm())
+
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "exchanged-" + r.PostForm.Get("subject_token"), ExpiresIn: 3600})
+	}))
+	defer srv.Close()
+
+	logger := logging.NewNoopLogger()
+	s := NewService(srv.URL, "authorizer", "secret", cache.NewMemory(), tracing.NewNoopTracer(), monitoring.NewNoopMonitor("test", logger), logger)
+
+	// tokens of two issuers carrying the same sub never share the exchanged token
+	for _, subjectToken := range []string{"issuer-a-token", "issuer-b-token"} {
+		token, err := s.Exchange(context.TODO(), subjectToken, "orders", nil)
+
+		assert.Nil(err)
+		assert.Equal("exchanged-"+subjectToken, token)
+	}
+}
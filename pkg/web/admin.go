@@ -0,0 +1,60 @@
+// Copyright 2024 Canonical Ltd.
+// SPDX-License-Identifier: AGPL-3.0
+
+package web
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/shipperizer/iam-ext-authz/internal/logging"
+	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+	"github.com/shipperizer/iam-ext-authz/pkg/authz"
+	"github.com/shipperizer/iam-ext-authz/pkg/metrics"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/sessions"
+	"github.com/shipperizer/iam-ext-authz/pkg/status"
+)
+
+// NewAdminRouter serves the operational endpoints on the admin listener, it must not be
+// reachable from outside the cluster, the profiling endpoints are only served when pprof is set
+func NewAdminRouter(sessionCache *authz.SessionCache, webhookSecret string, policies *policy.Store, tenantPolicies map[string]*policy.Store, policyAdminSecret string, pprof bool, cfg O11yConfigInterface) http.Handler {
+	router := chi.NewMux()
+
+	logger := cfg.Logger()
+	monitor := cfg.Monitor()
+	tracer := cfg.Tracer()
+
+	router.Use(
+		middleware.RequestID,
+		monitoring.NewMiddleware(monitor, logger).ResponseTime(),
+		middleware.RequestLogger(logging.NewLogFormatter(logger)),
+	)
+
+	statusAPI := status.NewAPI(tracer, monitor, logger)
+	metricsAPI := metrics.NewAPI(logger)
+	sessionsAPI := sessions.NewAPI(webhookSecret, sessionCache, tracer, logger)
+	// tenant policies are read only, uploads only replace the default ones
+	tenantStores := make(map[string]policy.StoreInterface, len(tenantPolicies))
+
+	for name, store := range tenantPolicies {
+		tenantStores[name] = store
+	}
+
+	policyAPI := policy.NewAPI(policyAdminSecret, policies, tenantStores, tracer, logger)
+
+	// register endpoints as last step
+	statusAPI.RegisterEndpoints(router)
+	metricsAPI.RegisterEndpoints(router)
+	sessionsAPI.RegisterEndpoints(router)
+	policyAPI.RegisterEndpoints(router)
+
+	if pprof {
+		router.Mount("/debug", middleware.Profiler())
+	}
+
+	return tracing.NewMiddleware(monitor, logger).OpenTelemetry(router)
+}
@@ -4,6 +4,7 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -13,13 +14,77 @@ import (
 	ik "github.com/shipperizer/iam-ext-authz/internal/kratos"
 	"github.com/shipperizer/iam-ext-authz/internal/logging"
 	"github.com/shipperizer/iam-ext-authz/internal/monitoring"
+	"github.com/shipperizer/iam-ext-authz/internal/ratelimit"
 	"github.com/shipperizer/iam-ext-authz/internal/tracing"
+	"github.com/shipperizer/iam-ext-authz/pkg/apikey"
 	"github.com/shipperizer/iam-ext-authz/pkg/authz"
-	"github.com/shipperizer/iam-ext-authz/pkg/metrics"
-	"github.com/shipperizer/iam-ext-authz/pkg/status"
+	"github.com/shipperizer/iam-ext-authz/pkg/dpop"
+	"github.com/shipperizer/iam-ext-authz/pkg/oidc"
+	"github.com/shipperizer/iam-ext-authz/pkg/opa"
+	"github.com/shipperizer/iam-ext-authz/pkg/policy"
+	"github.com/shipperizer/iam-ext-authz/pkg/provider"
+	"github.com/shipperizer/iam-ext-authz/pkg/relyingparty"
+	"github.com/shipperizer/iam-ext-authz/pkg/resourcemetadata"
+	"github.com/shipperizer/iam-ext-authz/pkg/tenant"
+	"github.com/shipperizer/iam-ext-authz/pkg/tokenexchange"
 )
 
-func NewRouter(kratos *ik.Client, hydra *ih.Client, cfg O11yConfigInterface) http.Handler {
+// RouterConfig carries the backends and optional services of the public router, nil services
+// are disabled
+type RouterConfig struct {
+	Kratos          *ik.Client
+	Hydra           *ih.Client
+	SessionCache    *authz.SessionCache
+	SessionExtender *authz.SessionExtender
+	Policies        *policy.Store
+	Limiter         ratelimit.LimiterInterface
+	// NewProviderService builds the login and consent provider service of a kratos and hydra pair
+	NewProviderService func(*ik.Client, *ih.Client) provider.ServiceInterface
+	LoginUIURL         string
+	ConsentUIURL       string
+	RelyingParty       *relyingparty.Service
+	Exchanger          *tokenexchange.Service
+	DPoP               *dpop.Validator
+	APIKeys            *apikey.Store
+	JWTIssuers         *oidc.Issuers
+	ServiceAccounts    authz.ServiceAccountValidatorInterface
+	Rego               *opa.Evaluator
+	Debug              *authz.DebugConfig
+	Challenges         *authz.ChallengeConfig
+	// Issuer is the authorization server listed in the protected resource metadata, the
+	// metadata endpoints are disabled when empty
+	Issuer       string
+	MaxBodyBytes int64
+	// TrustedProxyHops is the number of proxies appending to X-Forwarded-For
+	TrustedProxyHops int
+	// XFCCBy is the URI SAN of the proxy setting x-forwarded-client-cert
+	XFCCBy  string
+	Tenants *tenant.Table
+	// TenantPolicies are the policy stores of the tenants by name
+	TenantPolicies map[string]*policy.Store
+	// ClientDebug enables the debug logs of the tenant kratos and hydra clients
+	ClientDebug bool
+}
+
+// backends are the kratos and hydra pair serving the check and the login and consent provider,
+// either the default ones or the ones of a tenant
+type backends struct {
+	Kratos          *ik.Client
+	Hydra           *ih.Client
+	SessionCache    *authz.SessionCache
+	SessionExtender *authz.SessionExtender
+	Policies        authz.PoliciesInterface
+	LoginUIURL      string
+	ConsentUIURL    string
+	IdentityHeaders map[string]string
+	// Issuer is the authorization server listed in the protected resource metadata, the
+	// metadata endpoints are disabled when empty
+	Issuer string
+	// Default is set on the backends configured in the environment
+	Default bool
+}
+
+func NewRouter(c *RouterConfig, cfg O11yConfigInterface) http.Handler {
 	router := chi.NewMux()
 
 	logger := cfg.Logger()
@@ -30,8 +95,8 @@ func NewRouter(kratos *ik.Client, hydra *ih.Client, cfg O11yConfigInterface) htt
 	middlewares = append(
 		middlewares,
 		middleware.RequestID,
+		logging.RequestContext(logger),
 		monitoring.NewMiddleware(monitor, logger).ResponseTime(),
-		middlewareCORS([]string{"*"}),
 	)
 
 	// TODO @shipperizer add a proper configuration to enable http logger middleware as it's expensive
@@ -44,14 +109,132 @@ func NewRouter(kratos *ik.Client, hydra *ih.Client, cfg O11yConfigInterface) htt
 
 	router.Use(middlewares...)
 
-	statusAPI := status.NewAPI(tracer, monitor, logger)
-	metricsAPI := metrics.NewAPI(logger)
-	extAuthzAPI := authz.NewAPI(authz.NewService(kratos, hydra, tracer, monitor, logger), logger)
+	// relying party mode, JWT validation, token exchange, rego and API keys are optional, keep the
+	// interfaces nil when disabled
+	authzConfig := &authz.Config{
+		Limiter:          c.Limiter,
+		Debug:            c.Debug,
+		Challenges:       c.Challenges,
+		MaxBodyBytes:     c.MaxBodyBytes,
+		TrustedProxyHops: c.TrustedProxyHops,
+		XFCCBy:           c.XFCCBy,
+	}
+
+	if c.Rego != nil {
+		authzConfig.Rego = c.Rego
+	}
+
+	// tenantRoutes builds the routes depending on the kratos and hydra backends, once for the
+	// default backends and once per tenant
+	tenantRoutes := func(b *backends) *chi.Mux {
+		mux := chi.NewMux()
+
+		authzService := authz.NewService(b.Kratos, b.Hydra, b.SessionCache, b.SessionExtender, tracer, monitor, logger)
+
+		var apiKeyStore authz.APIKeyStoreInterface
+
+		if b.Default && c.APIKeys != nil {
+			apiKeyStore = c.APIKeys
+		}
+
+		authenticators := []authz.AuthenticatorInterface{
+			authz.NewIntrospectionAuthenticator(authzService, c.DPoP, logger),
+			authz.NewKratosCookieAuthenticator(authzService, logger),
+			authz.NewKratosSessionTokenAuthenticator(authzService, logger),
+			authz.NewClientCertificateAuthenticator(logger),
+			authz.NewAPIKeyAuthenticator(apiKeyStore, logger),
+			authz.NewAnonymousAuthenticator(),
+		}
+
+		if c.ServiceAccounts != nil {
+			authenticators = append(authenticators, authz.NewServiceAccountAuthenticator(c.ServiceAccounts, logger))
+		}
+
+		backendConfig := *authzConfig
+		backendConfig.IdentityHeaders = b.IdentityHeaders
+
+		// relying party, JWT issuers, API keys and token exchange are bound to the issuers of
+		// the default backends, tenant policies can't use them
+		if b.Default {
+			if c.RelyingParty != nil {
+				authenticators = append(authenticators, authz.NewRelyingPartyAuthenticator(c.RelyingParty, logger))
+			}
+
+			if c.JWTIssuers != nil {
+				authenticators = append(authenticators, authz.NewJWTAuthenticator(c.JWTIssuers, c.DPoP, logger))
+			}
+
+			if c.Exchanger != nil {
+				backendConfig.Exchanger = c.Exchanger
+			}
+		}
+
+		authz.NewAPI(b.Policies, authenticators, &backendConfig, logger).RegisterEndpoints(mux)
+		provider.NewAPI(b.LoginUIURL, b.ConsentUIURL, c.NewProviderService(b.Kratos, b.Hydra), logger).RegisterEndpoints(mux)
+
+		// resource metadata describes the resources protected by the policies of the backends,
+		// the JWT issuers are only trusted on the default ones
+		if b.Issuer != "" {
+			var jwtIssuers []string
+
+			if b.Default && c.JWTIssuers != nil {
+				jwtIssuers = c.JWTIssuers.Names()
+			}
+
+			resourcemetadata.NewAPI(b.Issuer, jwtIssuers, b.Policies, logger).RegisterEndpoints(mux)
+		}
+
+		return mux
+	}
+
+	defaultBackends := &backends{
+		Kratos:          c.Kratos,
+		Hydra:           c.Hydra,
+		SessionCache:    c.SessionCache,
+		SessionExtender: c.SessionExtender,
+		Policies:        c.Policies,
+		LoginUIURL:      c.LoginUIURL,
+		ConsentUIURL:    c.ConsentUIURL,
+		Issuer:          c.Issuer,
+		Default:         true,
+	}
+
+	tenantAPI := tenant.NewAPI(
+		c.Tenants,
+		[]string{
+			"/api/v0/check", "/api/v0/check/*", "/api/v0/traefik/check", "/api/v0/nginx/check", "/api/v0/oauth2/login", "/api/v0/oauth2/consent",
+			resourcemetadata.WellKnownPath, resourcemetadata.WellKnownPath + "/*",
+		},
+		authz.OriginalHost,
+		tenantRoutes(defaultBackends),
+		func(t *tenant.Tenant) (http.Handler, error) {
+			tenantPolicies, ok := c.TenantPolicies[t.Name]
+
+			if !ok {
+				return nil, fmt.Errorf("no policies loaded for tenant %s", t.Name)
+			}
+
+			// sessions are only extended on the default backends
+			return tenantRoutes(&backends{
+				Kratos:          ik.NewClient(t.KratosPublicURL, c.ClientDebug),
+				Hydra:           ih.NewClient(t.HydraAdminURL, c.ClientDebug),
+				SessionCache:    c.SessionCache.Namespace("tenant:" + t.Name),
+				Policies:        tenantPolicies,
+				LoginUIURL:      t.LoginUIURL,
+				ConsentUIURL:    t.ConsentUIURL,
+				IdentityHeaders: t.IdentityHeaders,
+				Issuer:          t.Issuer,
+			}), nil
+		},
+		logger,
+	)
 
 	// register endpoints as last step
-	statusAPI.RegisterEndpoints(router)
-	metricsAPI.RegisterEndpoints(router)
-	extAuthzAPI.RegisterEndpoints(router)
+	tenantAPI.RegisterEndpoints(router)
+
+	if c.RelyingParty != nil {
+		relyingparty.NewAPI(c.RelyingParty, logger).RegisterEndpoints(router)
+	}
 
 	return tracing.NewMiddleware(monitor, logger).OpenTelemetry(router)
 }
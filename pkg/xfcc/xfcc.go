@@ -0,0 +1,171 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+// Package xfcc parses the envoy x-forwarded-client-cert header
+package xfcc
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const Header = "X-Forwarded-Client-Cert"
+
+// Certificate describes the client certificate presented to the proxy
+type Certificate struct {
+	// Thumbprint is the base64url encoded SHA-256 of the DER certificate, as used by
+	// the x5t#S256 confirmation method of RFC 8705
+	Thumbprint string
+	Subject    string
+	URIs       []string
+	DNS        []string
+	By         string
+}
+
+// Parse returns the certificate of the element added by the proxy whose certificate carries
+// the by URI, elements added by other proxies or sent by clients are ignored; nil if the header
+// has no such element
+func Parse(header, by string) (*Certificate, error) {
+	if strings.TrimSpace(header) == "" || by == "" {
+		return nil, nil
+	}
+
+	elements := splitUnquoted(header, ',')
+
+	// the closest proxies append last
+	for i := len(elements) - 1; i >= 0; i-- {
+		c, err := parseElement(elements[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if c.By == by {
+			return c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func parseElement(element string) (*Certificate, error) {
+	c := new(Certificate)
+
+	var certPEM, hash string
+
+	for _, pair := range splitUnquoted(element, ';') {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+
+		if !found {
+			return nil, fmt.Errorf("malformed XFCC pair %q", pair)
+		}
+
+		value = unquote(value)
+
+		switch strings.ToLower(key) {
+		case "by":
+			c.By = value
+		case "hash":
+			hash = value
+		case "cert":
+			pemValue, err := url.QueryUnescape(value)
+
+			if err != nil {
+				return nil, fmt.Errorf("malformed XFCC cert: %w", err)
+			}
+
+			certPEM = pemValue
+		case "subject":
+			c.Subject = value
+		case "uri":
+			c.URIs = append(c.URIs, value)
+		case "dns":
+			c.DNS = append(c.DNS, value)
+		}
+	}
+
+	if certPEM != "" {
+		if err := c.fromPEM(certPEM); err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	if hash != "" {
+		raw, err := hex.DecodeString(hash)
+
+		if err != nil {
+			return nil, fmt.Errorf("malformed XFCC hash: %w", err)
+		}
+
+		c.Thumbprint = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	return c, nil
+}
+
+// fromPEM overrides the header attributes with the ones from the certificate itself
+func (c *Certificate) fromPEM(certPEM string) error {
+	block, _ := pem.Decode([]byte(certPEM))
+
+	if block == nil {
+		return fmt.Errorf("malformed XFCC cert PEM")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		return fmt.Errorf("malformed XFCC cert: %w", err)
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+
+	c.Thumbprint = base64.RawURLEncoding.EncodeToString(sum[:])
+	c.Subject = cert.Subject.String()
+	c.DNS = cert.DNSNames
+	c.URIs = make([]string, 0, len(cert.URIs))
+
+	for _, u := range cert.URIs {
+		c.URIs = append(c.URIs, u.String())
+	}
+
+	return nil
+}
+
+// splitUnquoted splits s on sep ignoring separators inside double quotes
+func splitUnquoted(s string, sep rune) []string {
+	parts := make([]string, 0)
+	quoted, escaped := false, false
+	start := 0
+
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	}
+
+	return value
+}
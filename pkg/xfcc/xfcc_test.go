@@ -0,0 +1,88 @@
+// Copyright 2024 Canonical Ltd
+// SPDX-License-Identifier: AGPL-3.0
+
+package xfcc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCert(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/default/sa/orders")
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "orders"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{spiffe},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	sum := sha256.Sum256(der)
+
+	header := fmt.Sprintf(
+		`By=spiffe://cluster.local/ns/a/sa/proxy;Hash=deadbeef;URI=spiffe://ignored,By=spiffe://cluster.local/ns/b/sa/gw;Cert="%s";Subject="CN=orders";URI=%s`,
+		url.QueryEscape(string(certPEM)), spiffe,
+	)
+
+	cert, err := Parse(header, "spiffe://cluster.local/ns/b/sa/gw")
+	assert.Nil(err)
+	assert.Equal(base64.RawURLEncoding.EncodeToString(sum[:]), cert.Thumbprint)
+	assert.Equal([]string{spiffe.String()}, cert.URIs)
+	assert.Equal("CN=orders", cert.Subject)
+	assert.Equal("spiffe://cluster.local/ns/b/sa/gw", cert.By)
+
+	cert, err = Parse(header, "spiffe://cluster.local/ns/a/sa/proxy")
+	assert.Nil(err)
+	assert.Equal([]string{"spiffe://ignored"}, cert.URIs)
+
+	cert, err = Parse(header, "spiffe://cluster.local/ns/c/sa/other")
+	assert.Nil(err)
+	assert.Nil(cert, "elements of other proxies are never trusted")
+}
+
+func TestParseHashOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	sum := sha256.Sum256([]byte("certificate"))
+
+	cert, err := Parse(fmt.Sprintf(`By=spiffe://gw;Hash=%s;Subject="CN=a\"b,O=org";URI=spiffe://x`, hex.EncodeToString(sum[:])), "spiffe://gw")
+	assert.Nil(err)
+	assert.Equal(base64.RawURLEncoding.EncodeToString(sum[:]), cert.Thumbprint)
+	assert.Equal(`CN=a"b,O=org`, cert.Subject)
+	assert.Equal([]string{"spiffe://x"}, cert.URIs)
+}
+
+func TestParseEmpty(t *testing.T) {
+	cert, err := Parse("", "spiffe://gw")
+
+	assert.Nil(t, err)
+	assert.Nil(t, cert)
+
+	cert, err = Parse("By=spiffe://gw;URI=spiffe://x", "")
+
+	assert.Nil(t, err)
+	assert.Nil(t, cert, "the header is ignored unless the proxy is configured")
+}